@@ -1,26 +1,32 @@
 package abtest
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
 	"github.com/phoenix-rec/abtest-sdk-go/abtest/consts"
 	logger "github.com/phoenix-rec/abtest-sdk-go/abtest/log"
 	abtest "github.com/phoenix-rec/abtest-sdk-go/abtest/proto"
-	"io/ioutil"
-	"net/http"
+	"github.com/phoenix-rec/abtest-sdk-go/abtest/utils"
+	"os"
 	"reflect"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// ABClient loads the configs of a project and evaluates its experiments.
+//
+// The methods suffixed with Context evaluate for the request in ctx. A user attached by
+// proto.NewUserContext is matched against the targeting rules when its Id is the id evaluated,
+// so it decides which strategy is served, and a request scope created by exposure.NewContext
+// lets the exposure filter de-duplicate the exposures of the request.
+// The methods without ctx evaluate with neither.
 type ABClient struct {
-	abAdapter *http.Client
+	source abtest.ConfigSource
 
 	typeMask uint
 
-	infoMap atomic.Value // map[int64]map[string]*abtest.ExperimentInfo
+	view atomic.Value // *view, the compiled configs being served
 
 	interval               int          // in second
 	ticker                 *time.Ticker // init at ABClient.Open
@@ -28,12 +34,35 @@ type ABClient struct {
 	serverUnavailableTicks int
 	ticksToSkip            int
 
+	lastResync time.Time
+
+	streaming    int32 // 1 while the config stream is up
+	stopStream   context.CancelFunc
+	streamClosed chan struct{} // closed once the stream goroutine returns, nil without a stream
+
+	sm                  sync.Mutex    // guards the fields below
+	ready               chan struct{} // closed once the configs are loaded
+	lastErr             error
+	lastSync            time.Time
+	consecutiveFailures int
+	nextRetry           time.Time
+	readyRetryDelay     time.Duration // backoff of the retries of WaitReady, shared by all waiters
+	nextReadyRetry      time.Time
+
 	m         sync.Mutex
-	closeChan chan bool
+	running   int32         // 1 while open, read atomically
+	done      chan struct{} // closed by Close to stop the worker
+	stopped   chan struct{} // closed once the worker returns
 	errCount  uint64
+	conflicts uint64   // keys set by several experiments in GetConfig
+	expired   sync.Map // expiredKey => true, expired experiments already logged
+	listeners listeners
+	snapshot  snapshotWriter
 
 	hostport  string
 	projectId int64
+
+	options abtest.Options
 }
 
 func (c *ABClient) Open(r ConfigReader, hostport string, interval int, projectId int64) ConfigReader {
@@ -45,8 +74,13 @@ func (c *ABClient) Open(r ConfigReader, hostport string, interval int, projectId
 		return c
 	}
 
-	c.closeChan = make(chan bool)
-	c.abAdapter = &http.Client{}
+	c.done = make(chan struct{})
+	c.stopped = make(chan struct{})
+	c.ready = make(chan struct{})
+	c.source = c.options.Source
+	if c.source == nil {
+		c.source = NewHTTPSource(hostport)
+	}
 	c.projectId = projectId
 	c.hostport = hostport
 	c.interval = interval
@@ -58,28 +92,38 @@ func (c *ABClient) Open(r ConfigReader, hostport string, interval int, projectId
 	}
 	c.ticker = time.NewTicker(time.Duration(interval) * time.Second)
 
-	c.infoMap.Store(make(map[int64]map[string]*abtest.ExperimentInfo))
+	c.storeInfoMap(make(map[int64]map[string]*abtest.ExperimentInfo))
+	c.restoreSnapshot()
+	c.lastResync = time.Now()
+
 	err := r.Update()
 	if err != nil {
 		logger.ErrorF("ABClient init err: %v", err)
-		logger.Error(ErrAllDefault)
+		if atomic.LoadInt64(&c.ut) == 0 {
+			logger.Error(ErrAllDefault)
+		}
 	}
+	c.scheduleRetry(1)
 
-	go func() {
-		for c.isRunning() {
-			c.work(r)
+	atomic.StoreInt32(&c.running, 1)
+	go func(done <-chan struct{}, stopped chan<- struct{}) {
+		defer close(stopped)
+		for c.work(r, done) {
 		}
-	}()
+	}(c.done, c.stopped)
+	c.startStream()
 
 	return c
 }
 
 func (c *ABClient) isRunning() bool {
-	return c != nil && c.closeChan != nil
+	return c != nil && atomic.LoadInt32(&c.running) == 1
 }
 
+// work waits for the next tick and polls, returning false once done is closed.
 // work is not safe for concurrent use.
-func (c *ABClient) work(r ConfigReader) {
+func (c *ABClient) work(r ConfigReader, done <-chan struct{}) (running bool) {
+	running = true
 	defer func() {
 		if err := recover(); err != nil {
 			logger.ErrorF("worker err: %v", err)
@@ -87,21 +131,33 @@ func (c *ABClient) work(r ConfigReader) {
 	}()
 
 	select {
-	case <-c.closeChan:
+	case <-done:
 		c.ticker.Stop()
-		return
+		return false
 	case <-c.ticker.C:
+		full := c.resyncDue()
+		if atomic.LoadInt32(&c.streaming) == 1 && !full {
+			// Updates are pushed by the stream.
+			return
+		}
+
 		if c.ticksToSkip > 0 {
 			c.ticksToSkip -= 1
 			return
 		}
 
-		err := r.Update()
+		var err error
+		if full {
+			err = c.Resync()
+		} else {
+			err = r.Update()
+		}
 		if err != nil {
 			c.serverUnavailableTicks = (c.serverUnavailableTicks << 1) + 1
 			c.ticksToSkip = c.serverUnavailableTicks
+			c.scheduleRetry(c.ticksToSkip + 1)
 
-			if c.ut == 0 {
+			if atomic.LoadInt64(&c.ut) == 0 {
 				logger.ErrorF("Update err: %v", err)
 				logger.Error(ErrAllDefault)
 			} else {
@@ -111,6 +167,7 @@ func (c *ABClient) work(r ConfigReader) {
 
 		} else {
 			c.serverUnavailableTicks, c.ticksToSkip = 0, 0
+			c.scheduleRetry(1)
 		}
 	}
 
@@ -118,23 +175,124 @@ func (c *ABClient) work(r ConfigReader) {
 }
 
 func (c *ABClient) Update() (err error) {
-	remoteInfoMap, err := c.remoteInfoMap()
+	err = c.update(false)
+	c.writeSnapshot()
+	c.recordResult(err)
+	c.notify()
+
+	return
+}
+
+// Resync rebuilds the local configs from the full config list,
+// dropping anything that incremental updates failed to remove.
+func (c *ABClient) Resync() (err error) {
+	err = c.update(true)
+	c.writeSnapshot()
+	c.recordResult(err)
+	c.notify()
+
+	return
+}
+
+func (c *ABClient) update(full bool) (err error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	ut := c.ut
+	if full {
+		ut = 0
+	}
+
+	data, err := c.source.GetConfigList(ut)
 	if err != nil {
 		return
 	}
+	if data == nil {
+		err = fmt.Errorf("config source returned no data")
+		return
+	}
 
-	if len(remoteInfoMap) == 0 {
+	if full {
+		c.lastResync = time.Now()
+		if len(data.ConfigListMap) == 0 && !data.Full {
+			// An empty list without the full flag is more likely a broken source than
+			// a project without experiments, keep the local configs.
+			logger.Warn("full config list is empty, skip resync")
+			return
+		}
+	}
+
+	c.merge(data, full)
+
+	return
+}
+
+func (c *ABClient) resyncDue() bool {
+	return c.options.FullResyncInterval > 0 &&
+		time.Since(c.lastResync) >= time.Duration(c.options.FullResyncInterval)*time.Second
+}
+
+// apply merges an update pushed by the config source, unless the client is closed.
+func (c *ABClient) apply(data *abtest.GetConfigListData) {
+	if !c.isRunning() {
+		return
+	}
+
+	c.m.Lock()
+	c.merge(data, false)
+	c.m.Unlock()
+	c.writeSnapshot()
+
+	c.recordResult(nil)
+	c.notify()
+}
+
+// merge applies the experiments in data on top of the local configs,
+// or replaces the local configs with them if full is set,
+// and queues what changed for notify. c.m must be held.
+func (c *ABClient) merge(data *abtest.GetConfigListData, full bool) {
+	full = full || data.Full
+	remoteInfoMap := toInfoMap(data)
+	atomic.StoreInt64(&c.ut, data.Time)
+
+	if len(remoteInfoMap) == 0 && len(data.DeletedMap) == 0 && !data.Full {
 		// Already up to date.
 		return
 	}
 
-	logger.TraceF("%d project experiment(s) to update", len(remoteInfoMap))
+	logger.TraceF("%d project experiment(s) to update, full: %v", len(remoteInfoMap), full)
 
 	atomic.StoreUint64(&c.errCount, 0)
-	c.m.Lock()
-	defer c.m.Unlock()
 
-	localInfoMap, _ := c.infoMap.Load().(map[int64]map[string]*abtest.ExperimentInfo)
+	// The maps of the current version are still being read, copy them on write.
+	var currentInfoMap map[int64]map[string]*abtest.ExperimentInfo
+	if v := c.loadView(); v != nil {
+		currentInfoMap = v.infoMap
+	}
+	localInfoMap := make(map[int64]map[string]*abtest.ExperimentInfo)
+	if !full {
+		for projectId, exp := range currentInfoMap {
+			localInfoMap[projectId] = exp
+		}
+	}
+
+	// Tombstones remove experiments from the local configs before the updates are merged,
+	// so an experiment deleted and created again is kept.
+	for projectId, expNames := range data.DeletedMap {
+		exp, ok := localInfoMap[projectId]
+		if !ok {
+			continue
+		}
+
+		infoMap := make(map[string]*abtest.ExperimentInfo, len(exp))
+		for expName, info := range exp {
+			infoMap[expName] = info
+		}
+		for _, expName := range expNames {
+			delete(infoMap, expName)
+		}
+		localInfoMap[projectId] = infoMap
+	}
 
 	for projectId, exp := range localInfoMap {
 		if infoMap, ok := remoteInfoMap[projectId]; !ok {
@@ -149,157 +307,153 @@ func (c *ABClient) Update() (err error) {
 		}
 	}
 
-	c.infoMap.Store(remoteInfoMap)
+	event := diffInfoMap(currentInfoMap, remoteInfoMap)
+	event.Version = data.Time
+	c.storeInfoMap(remoteInfoMap)
+	c.listeners.enqueue(event)
+
+	if len(c.options.SnapshotPath) > 0 {
+		c.snapshot.set(data.Time, remoteInfoMap)
+	}
 
 	return
 }
 
-func (c *ABClient) remoteInfoMap() (projectInfoMap map[int64]map[string]*abtest.ExperimentInfo, err error) {
-	projectInfoMap = make(map[int64]map[string]*abtest.ExperimentInfo)
-
-	param := map[string]interface{}{
-		"time": c.ut,
+// restoreSnapshot serves the configs persisted by the last run until the first
+// successful update, in case the A/B server is unreachable on startup.
+func (c *ABClient) restoreSnapshot() {
+	if len(c.options.SnapshotPath) == 0 {
+		return
 	}
 
-	resp, err := c.getConfigList(param)
+	data, err := loadSnapshot(c.options.SnapshotPath)
 	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.WarnF("load snapshot err: %v", err)
+		}
 		return
 	}
-	if resp.Ret != 1 || resp.Data == nil {
-		err = fmt.Errorf("unexpected resp: %v", resp)
-		return
-	}
 
-	for projectId, expList := range resp.Data.ConfigListMap {
+	c.storeInfoMap(toInfoMap(data))
+	c.ut = data.Time
+	logger.InfoF("loaded snapshot %s, version: %d", c.options.SnapshotPath, c.ut)
+}
+
+// storeInfoMap compiles projectInfoMap and serves it.
+// Everything derived from the experiments is swapped in at once,
+// so readers never see the experiments of a version with the layers of another.
+func (c *ABClient) storeInfoMap(projectInfoMap map[int64]map[string]*abtest.ExperimentInfo) {
+	c.view.Store(newView(projectInfoMap, c.options.InheritDefault))
+}
+
+func toInfoMap(data *abtest.GetConfigListData) (projectInfoMap map[int64]map[string]*abtest.ExperimentInfo) {
+	projectInfoMap = make(map[int64]map[string]*abtest.ExperimentInfo)
+	for projectId, expList := range data.ConfigListMap {
 		infoMap := make(map[string]*abtest.ExperimentInfo)
 		for _, info := range expList {
+			if info.Invalid != nil {
+				logger.WarnF("project %d: experiment %s invalid: %v", projectId, info.Name, info.Invalid)
+			}
 			infoMap[info.Name] = info
 		}
 		projectInfoMap[projectId] = infoMap
 	}
-	c.ut = resp.Data.Time
 
 	return
 }
 
+// Close stops the updates and waits for the worker and the stream to return,
+// so no update is applied once it returns.
+// It is safe to call concurrently with the getters, which fail with ErrClientStopped afterwards.
 func (c *ABClient) Close() {
-	if !c.isRunning() {
-		return
-	}
-
-	c.closeChan <- true
-	c.closeChan = nil
-
-	return
-}
-
-func (c *ABClient) GetConfig(id string) (result map[string]interface{}, err error) {
-	result = make(map[string]interface{})
-	if !c.isRunning() {
-		err = ErrClientStopped
+	if c == nil || !atomic.CompareAndSwapInt32(&c.running, 1, 0) {
 		return
 	}
 
-	infoMap, ok := c.infoMap.Load().(map[int64]map[string]*abtest.ExperimentInfo)
-	if !ok || infoMap == nil {
-		err = ErrClientUninitialized
-		return
+	if c.stopStream != nil {
+		c.stopStream()
 	}
 
-	expInfoMap, ok := infoMap[c.projectId]
-	if !ok {
-		err = ErrProjectNotFound
-		return
+	close(c.done)
+	<-c.stopped
+	if c.streamClosed != nil {
+		<-c.streamClosed
 	}
+	c.listeners.close()
 
-	for expName, info := range expInfoMap {
-		if info.Status == abtest.Disabled {
-			continue
-		}
-
-		expConfig, expErr := c.GetExperiment(id, expName)
-		if expErr != nil {
-			err = expErr
-			continue
-		}
+	return
+}
 
-		for k, v := range expConfig {
-			result[k] = v
-		}
-	}
+// GetConfig merges the configs of id in every enabled experiment of the project.
+// A key set by several experiments is merged by the ConflictPolicy of the client.
+func (c *ABClient) GetConfig(id string) (result map[string]interface{}, err error) {
+	return c.GetConfigContext(context.Background(), id)
+}
 
+// GetConfigContext is like GetConfig, evaluating for the request in ctx, see ABClient.
+func (c *ABClient) GetConfigContext(ctx context.Context, id string) (result map[string]interface{}, err error) {
+	result, _, err = c.GetConfigWithProvenanceContext(ctx, id)
 	return
 }
 
 func (c *ABClient) GetExperiments(id string) (experiments map[string]map[string]interface{}, err error) {
+	return c.GetExperimentsContext(context.Background(), id)
+}
+
+// GetExperimentsContext is like GetExperiments, evaluating for the request in ctx, see ABClient.
+func (c *ABClient) GetExperimentsContext(ctx context.Context, id string) (experiments map[string]map[string]interface{}, err error) {
 	experiments = make(map[string]map[string]interface{})
 	if !c.isRunning() {
 		err = ErrClientStopped
 		return
 	}
 
-	infoMap, ok := c.infoMap.Load().(map[int64]map[string]*abtest.ExperimentInfo)
-	if !ok || infoMap == nil {
-		err = ErrClientUninitialized
-		return
-	}
-
-	expInfoMap, ok := infoMap[c.projectId]
-	if !ok {
-		err = ErrProjectNotFound
+	p, err := c.project()
+	if err != nil {
 		return
 	}
 
-	for expName, info := range expInfoMap {
+	for expName, e := range p.experiments {
+		info := e.info
 		if info.Status == abtest.Disabled {
 			continue
 		}
-		expConfig, expErr := info.GetConfig(id)
-		if expErr != nil {
+		detail, expErr := c.resolve(ctx, p, info, id)
+		if expErr != nil && expErr != ErrExperimentExpired {
 			err = expErr
 			continue
 		}
-
-		experiments[expName] = expConfig
+		experiments[expName] = e.config(detail.Strategy).copyValues()
 	}
 
 	return
 }
 
 func (c *ABClient) GetExperiment(id string, expName string) (result map[string]interface{}, err error) {
-	result = make(map[string]interface{})
-	if !c.isRunning() {
-		err = ErrClientStopped
-		return
-	}
-
-	infoMap, ok := c.infoMap.Load().(map[int64]map[string]*abtest.ExperimentInfo)
-	if !ok || infoMap == nil {
-		err = ErrClientUninitialized
-		return
-	}
-
-	expinfoMap, ok := infoMap[c.projectId]
-	if !ok {
-		err = ErrProjectNotFound
-		return
-	}
+	return c.GetExperimentContext(context.Background(), id, expName)
+}
 
-	info, ok := expinfoMap[expName]
-	if !ok {
-		err = ErrExperimentNotFound
-		return
-	}
+// GetExperimentContext is like GetExperiment, evaluating for the request in ctx, see ABClient.
+func (c *ABClient) GetExperimentContext(ctx context.Context, id string, expName string) (result map[string]interface{}, err error) {
+	result = make(map[string]interface{})
 
-	if info.Status == abtest.Disabled {
-		err = ErrExperimentDisabled
+	config, err := c.lookup(ctx, id, expName)
+	if err != nil && err != ErrExperimentExpired {
 		return
 	}
 
-	return info.GetConfig(id)
+	return config.copyValues(), err
 }
 
+// GetKey decodes the value of keyName in the config of id in the experiment into result.
+// keyName is a key of the config, or else a path into its nested values,
+// e.g. ranker.weights.ctr or slots[2].size.
 func (c *ABClient) GetKey(id, expName, keyName string, result interface{}) (err error) {
+	return c.GetKeyContext(context.Background(), id, expName, keyName, result)
+}
+
+// GetKeyContext is like GetKey, evaluating for the request in ctx, see ABClient.
+func (c *ABClient) GetKeyContext(ctx context.Context, id, expName, keyName string, result interface{}) (err error) {
 	if !c.isRunning() {
 		err = ErrClientStopped
 		return
@@ -317,14 +471,26 @@ func (c *ABClient) GetKey(id, expName, keyName string, result interface{}) (err
 		return
 	}
 
-	config, err := c.GetExperiment(id, expName)
-	if err != nil {
+	// An expired experiment serves the default strategy, still reporting ErrExperimentExpired.
+	config, expErr := c.lookup(ctx, id, expName)
+	if expErr != nil && expErr != ErrExperimentExpired {
+		err = expErr
 		return
 	}
+	defer func() {
+		if err == nil {
+			err = expErr
+		}
+	}()
 
-	val, ok := config[keyName]
-	if !ok {
-		err = ErrKeyNotFound
+	return config.decodeKey(keyName, result)
+}
+
+// decodeKey decodes the value of keyName into result, which must be a pointer.
+// A value that does not convert to the type of result returns a *TypeMismatchError.
+func (cv *configView) decodeKey(keyName string, result interface{}) (err error) {
+	val, err := cv.get(keyName)
+	if err != nil {
 		return
 	}
 
@@ -334,23 +500,11 @@ func (c *ABClient) GetKey(id, expName, keyName string, result interface{}) (err
 	}
 
 	if reflect.PtrTo(valType) == reflect.TypeOf(result) {
-		resultValue.Elem().Set(reflect.ValueOf(val))
-		return
-	}
-
-	bs, err := json.Marshal(val)
-	if err != nil {
+		reflect.ValueOf(result).Elem().Set(reflect.ValueOf(val))
 		return
 	}
 
-	err = json.Unmarshal(bs, result)
-	if err != nil {
-		elem := reflect.ValueOf(result).Elem()
-		elem.Set(reflect.Zero(elem.Type()))
-		return
-	}
-
-	return
+	return coerce(keyName, val, reflect.ValueOf(result).Elem())
 }
 
 func (c *ABClient) GetRawConfigs(expName string) (result map[string][]byte, err error) {
@@ -359,19 +513,17 @@ func (c *ABClient) GetRawConfigs(expName string) (result map[string][]byte, err
 		return
 	}
 
-	infoMap := c.infoMap.Load().(map[int64]map[string]*abtest.ExperimentInfo)
-
-	expInfoMap, ok := infoMap[c.projectId]
-	if !ok {
-		err = ErrProjectNotFound
+	p, err := c.project()
+	if err != nil {
 		return
 	}
 
-	info, ok := expInfoMap[expName]
+	e, ok := p.experiments[expName]
 	if !ok {
 		err = ErrExperimentNotFound
 		return
 	}
+	info := e.info
 
 	if info.Status == abtest.Disabled {
 		err = ErrExperimentDisabled
@@ -387,26 +539,29 @@ func (c *ABClient) GetRawConfig(id, expName string) (data []byte, err error) {
 		return
 	}
 
-	infoMap := c.infoMap.Load().(map[int64]map[string]*abtest.ExperimentInfo)
-
-	expInfoMap, ok := infoMap[c.projectId]
-	if !ok {
-		err = ErrProjectNotFound
+	p, err := c.project()
+	if err != nil {
 		return
 	}
 
-	info, ok := expInfoMap[expName]
+	e, ok := p.experiments[expName]
 	if !ok {
 		err = ErrExperimentNotFound
 		return
 	}
+	info := e.info
 
 	if info.Status == abtest.Disabled {
 		err = ErrExperimentDisabled
 		return
 	}
 
-	return info.GetRawConfig(id)
+	data, err = info.GetRawConfig(id)
+	if err == ErrExperimentExpired {
+		c.trackExpired(info)
+	}
+
+	return
 }
 
 func (c *ABClient) GetStrategyNamesByExpName(expName string) (strategies []string, err error) {
@@ -415,23 +570,17 @@ func (c *ABClient) GetStrategyNamesByExpName(expName string) (strategies []strin
 		return
 	}
 
-	infoMap, ok := c.infoMap.Load().(map[int64]map[string]*abtest.ExperimentInfo)
-	if !ok || infoMap == nil {
-		err = ErrClientUninitialized
-		return
-	}
-
-	expinfoMap, ok := infoMap[c.projectId]
-	if !ok {
-		err = ErrProjectNotFound
+	p, err := c.project()
+	if err != nil {
 		return
 	}
 
-	info, ok := expinfoMap[expName]
+	e, ok := p.experiments[expName]
 	if !ok {
 		err = ErrExperimentNotFound
 		return
 	}
+	info := e.info
 
 	m := make(map[string]bool)
 	for _, strategy := range info.StrategyNameTable {
@@ -443,35 +592,142 @@ func (c *ABClient) GetStrategyNamesByExpName(expName string) (strategies []strin
 	return
 }
 
+// GetStrategyName returns the strategy of userId in the experiment. A disabled experiment
+// still returns the strategy userId is whitelisted or hashed into, without logging an exposure,
+// while GetStrategyDetail reports it as the default strategy with reason DISABLED.
 func (c *ABClient) GetStrategyName(userId, expName string) (strategyName string, err error) {
+	return c.GetStrategyNameContext(context.Background(), userId, expName)
+}
+
+// GetStrategyNameContext is like GetStrategyName, evaluating for the request in ctx, see ABClient.
+func (c *ABClient) GetStrategyNameContext(ctx context.Context, userId, expName string) (strategyName string, err error) {
+	detail, err := c.GetStrategyDetailContext(ctx, userId, expName)
+	if err != nil && err != ErrExperimentExpired {
+		return
+	}
+
+	if detail.Reason == abtest.ReasonDisabled {
+		if p, pErr := c.project(); pErr == nil {
+			if e, ok := p.experiments[expName]; ok {
+				return disabledStrategy(e.info, userId), nil
+			}
+		}
+	}
+
+	return detail.Strategy, err
+}
+
+// disabledStrategy is the strategy id is whitelisted or hashed into in a disabled experiment.
+func disabledStrategy(info *abtest.ExperimentInfo, id string) string {
+	strategyName, ok := info.WhiteMap[id]
+	if !ok && info.PartitionCount > 0 && int(info.PartitionCount) == len(info.StrategyNameTable) {
+		strategyName = info.StrategyNameTable[utils.HashIndexBy(info.Hash, info.ExpID, id, info.PartitionCount)]
+	}
+	if len(strategyName) == 0 {
+		strategyName = consts.DefaultStrategyName
+	}
+	return strategyName
+}
+
+// GetStrategyDetail tells which strategy id is served in the experiment and why.
+// Failing to find the experiment reports the default strategy with reason ERROR.
+func (c *ABClient) GetStrategyDetail(id, expName string) (detail abtest.EvaluationDetail, err error) {
+	return c.GetStrategyDetailContext(context.Background(), id, expName)
+}
+
+// GetStrategyDetailContext is like GetStrategyDetail, evaluating for the request in ctx, see ABClient.
+func (c *ABClient) GetStrategyDetailContext(ctx context.Context, id, expName string) (detail abtest.EvaluationDetail, err error) {
+	detail = abtest.EvaluationDetail{
+		Strategy:  consts.DefaultStrategyName,
+		Reason:    abtest.ReasonError,
+		Partition: -1,
+	}
+
 	if !c.isRunning() {
 		err = ErrClientStopped
 		return
 	}
 
-	infoMap, ok := c.infoMap.Load().(map[int64]map[string]*abtest.ExperimentInfo)
-	if !ok || infoMap == nil {
-		err = ErrClientUninitialized
+	p, err := c.project()
+	if err != nil {
 		return
 	}
 
-	expinfoMap, ok := infoMap[c.projectId]
+	e, ok := p.experiments[expName]
 	if !ok {
-		err = ErrProjectNotFound
+		err = ErrExperimentNotFound
 		return
 	}
+	info := e.info
 
-	info, ok := expinfoMap[expName]
+	return c.resolve(ctx, p, info, id)
+}
+
+// resolve returns the strategy of id in the experiment of project p and records the exposure.
+func (c *ABClient) resolve(ctx context.Context, p *projectView, info *abtest.ExperimentInfo, id string) (detail abtest.EvaluationDetail, err error) {
+	ec := abtest.EvalContext{
+		Sticky: c.options.StickyStore,
+		Layers: p.layers,
+		User:   abtest.UserFromContext(ctx),
+	}
+	detail, err = info.Evaluate(id, &ec)
+	switch err {
+	case nil:
+	case ErrExperimentExpired:
+		c.trackExpired(info)
+	default:
+		return
+	}
+
+	c.logExposure(ctx, info, id, detail)
+
+	return
+}
+
+// GetLayerDetail picks the slot of id in the layer of the domain,
+// and resolves its strategy in the experiment occupying the slot.
+// A free slot reports no experiment with the default strategy and reason NOT_IN_LAYER.
+func (c *ABClient) GetLayerDetail(id, domain, layer string) (expName string, detail abtest.EvaluationDetail, err error) {
+	return c.GetLayerDetailContext(context.Background(), id, domain, layer)
+}
+
+// GetLayerDetailContext is like GetLayerDetail, evaluating for the request in ctx, see ABClient.
+func (c *ABClient) GetLayerDetailContext(ctx context.Context, id, domain, layer string) (expName string, detail abtest.EvaluationDetail, err error) {
+	detail = abtest.EvaluationDetail{
+		Strategy:  consts.DefaultStrategyName,
+		Reason:    abtest.ReasonError,
+		Partition: -1,
+	}
+
+	if !c.isRunning() {
+		err = ErrClientStopped
+		return
+	}
+
+	p, err := c.project()
+	if err != nil {
+		return
+	}
+
+	l, ok := p.layers[abtest.LayerKey(domain, layer)]
 	if !ok {
-		err = ErrExperimentNotFound
+		err = ErrLayerNotFound
+		return
+	}
+
+	expName = l.Owner(id)
+	e, ok := p.experiments[expName]
+	if !ok {
+		detail.Reason = abtest.ReasonNotInLayer
 		return
 	}
 
-	return info.GetStrategy(userId)
+	detail, err = c.resolve(ctx, p, e.info, id)
+	return
 }
 
 func (c *ABClient) TrackError(f, id, expName, keyName string, err error) {
-	if err == ErrExperimentNotMatch {
+	if err == ErrExperimentNotMatch || err == ErrExperimentExpired {
 		return
 	}
 
@@ -484,7 +740,7 @@ func (c *ABClient) TrackError(f, id, expName, keyName string, err error) {
 }
 
 func (c *ABClient) TrackErrorNew(f, id, expName, keyName string, err error) {
-	if err == ErrExperimentNotMatch {
+	if err == ErrExperimentNotMatch || err == ErrExperimentExpired {
 		return
 	}
 
@@ -496,35 +752,16 @@ func (c *ABClient) TrackErrorNew(f, id, expName, keyName string, err error) {
 	return
 }
 
-func (c *ABClient) apiRequest(url string, httpBody []byte) (resp []byte, err error) {
-	request, err := http.NewRequest("POST", url, bytes.NewReader(httpBody))
-	if err != nil {
-		return
-	}
-
-	// 发起请求
-	httpResp, err := c.abAdapter.Do(request)
-	if err != nil {
-		return
-	}
-
-	defer httpResp.Body.Close()
-	resp, err = ioutil.ReadAll(httpResp.Body)
-	if err != nil {
-		return
-	}
-
-	return
+type expiredKey struct {
+	projectId int64
+	expName   string
+	expire    int64
 }
 
-func (c *ABClient) getConfigList(param map[string]interface{}) (resp *abtest.DataResp, err error) {
-	resp = new(abtest.DataResp)
-	url := c.hostport + consts.DefaultAbApiPath
-	data, err := json.Marshal(param)
-	if err != nil {
-		return
+// trackExpired logs the first time an experiment is found expired.
+func (c *ABClient) trackExpired(info *abtest.ExperimentInfo) {
+	key := expiredKey{projectId: c.projectId, expName: info.Name, expire: info.Expire}
+	if _, loaded := c.expired.LoadOrStore(key, true); !loaded {
+		logger.WarnF("experiment %s expired at %s, serving the default strategy", info.Name, time.Unix(info.Expire, 0).Format(time.RFC3339))
 	}
-	respBody, err := c.apiRequest(url, data)
-	json.Unmarshal(respBody, &resp)
-	return
 }
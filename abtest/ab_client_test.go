@@ -35,7 +35,7 @@ func TestServerUnavailable2(t *testing.T) {
 	//opts = append(opts, abtest.WithInterval(10)) //默认实验config更新间隔，默认为10s
 	err := Open(16, opts...)
 	if err != nil {
-		fmt.Errorf("open fail, err: %v", err)
+		t.Errorf("open fail, err: %v", err)
 		return
 	}
 
@@ -1,226 +1,136 @@
 package abtest
 
 import (
+	"context"
+
 	jsoniter "github.com/json-iterator/go"
-	"github.com/phoenix-rec/abtest-sdk-go/abtest/consts"
-	logger "github.com/phoenix-rec/abtest-sdk-go/abtest/log"
 	proto "github.com/phoenix-rec/abtest-sdk-go/abtest/proto"
 )
 
-var client ConfigReader
+var defaultClient *Client
 var _json = jsoniter.ConfigCompatibleWithStandardLibrary
 
-// Open the A/B client which holds all the A/B configs in memory
+// Open the default A/B client which holds all the A/B configs in memory
 // and incrementally synchronizes data from server at intervals specified in conf.
+// The package level functions below all read from this client.
 func Open(projectId int64, opts ...proto.Option) (err error) {
-	if projectId == 0 {
-		err = ErrClientSettingErr
+	c, err := NewClient(projectId, opts...)
+	if err != nil {
 		return
 	}
 
-	opt := proto.Options{
-		Hostport: consts.DefaultAbConfigHost,
-		Interval: consts.DefaultIntervalInSecond,
-	}
-	for _, o := range opts {
-		o(&opt)
-	}
-
-	logger.InitDefaultLogger()
-	client = new(ABClient)
-	client.Open(client, opt.Hostport, opt.Interval, projectId)
+	defaultClient = c
 	return
 }
 
-func Close() {
-	if client == nil {
-		return
-	}
+// Default returns the client opened by Open, or nil if Open has not been called.
+func Default() *Client {
+	return defaultClient
+}
 
-	client.Close()
-	return
+// WaitReady blocks until the default client has loaded the configs, see Client.WaitReady.
+func WaitReady(ctx context.Context) error {
+	return defaultClient.WaitReady(ctx)
 }
 
-func GetConfig(id string) (config map[string]interface{}) {
-	if client == nil {
-		logger.Error(ErrClientUninitialized)
-		return make(map[string]interface{})
-	}
+func Close() {
+	defaultClient.Close()
+}
 
-	config, err := client.GetConfig(id)
-	if err != nil {
-		client.TrackError("GetConfig", id, "", "", err)
-	}
+func GetConfig(id string) (config map[string]interface{}) {
+	return defaultClient.GetConfig(id)
+}
 
-	return
+// GetConfigWithProvenance is like GetConfig, also returning which experiment every key is from.
+func GetConfigWithProvenance(id string) (config map[string]interface{}, provenance map[string]string) {
+	return defaultClient.GetConfigWithProvenance(id)
 }
 
 func GetExperiments(id string) (experiments map[string]map[string]interface{}) {
-	if client == nil {
-		logger.Error(ErrClientUninitialized)
-		return make(map[string]map[string]interface{})
-	}
-
-	experiments, err := client.GetExperiments(id)
-	if err != nil {
-		client.TrackError("GetExperiments", id, "", "", err)
-	}
-
-	return
+	return defaultClient.GetExperiments(id)
 }
 
 func GetExperiment(id, expName string) (exp map[string]interface{}) {
-	if client == nil {
-		logger.Error(ErrClientUninitialized)
-		return make(map[string]interface{})
-	}
-
-	exp, err := client.GetExperiment(id, expName)
-	if err != nil {
-		client.TrackError("GetExperiment", id, expName, "", err)
-	}
-
-	return
+	return defaultClient.GetExperiment(id, expName)
 }
 
 func GetStrategyNamesByExpName(expName string) (strategies []string, err error) {
-	if client == nil {
-		err = ErrClientUninitialized
-		return
-	}
-	return client.GetStrategyNamesByExpName(expName)
+	return defaultClient.GetStrategyNamesByExpName(expName)
 }
 
 func GetStrategyName(id, expName string) (strategy string, err error) {
-	if client == nil {
-		err = ErrClientUninitialized
-		return
-	}
-	return client.GetStrategyName(id, expName)
+	return defaultClient.GetStrategyName(id, expName)
 }
 
-func GetBool(id, expName, keyName string, defaultValue bool) (val bool) {
-	if client == nil {
-		logger.Error(ErrClientUninitialized)
-		return defaultValue
-	}
+func GetStrategyDetail(id, expName string) (detail proto.EvaluationDetail, err error) {
+	return defaultClient.GetStrategyDetail(id, expName)
+}
 
-	if err := client.GetKey(id, expName, keyName, &val); err != nil {
-		client.TrackError("GetBool", id, expName, keyName, err)
-		val = defaultValue
-	}
+func GetLayerDetail(id, domain, layer string) (expName string, detail proto.EvaluationDetail, err error) {
+	return defaultClient.GetLayerDetail(id, domain, layer)
+}
 
-	return
+func GetExperimentInto(id, expName string, dst interface{}) (err error) {
+	return defaultClient.GetExperimentInto(id, expName, dst)
 }
 
-func GetString(id, expName, keyName, defaultValue string) (val string) {
-	if client == nil {
-		logger.Error(ErrClientUninitialized)
-		return defaultValue
-	}
+func GetExperimentIntoWithDefaults(id, expName string, dst interface{}) (defaulted []string, err error) {
+	return defaultClient.GetExperimentIntoWithDefaults(id, expName, dst)
+}
 
-	if err := client.GetKey(id, expName, keyName, &val); err != nil {
-		client.TrackError("GetString", id, expName, keyName, err)
-		val = defaultValue
-	}
+func GetBool(id, expName, keyName string, defaultValue bool) (val bool) {
+	return defaultClient.GetBool(id, expName, keyName, defaultValue)
+}
 
-	return
+func GetString(id, expName, keyName, defaultValue string) (val string) {
+	return defaultClient.GetString(id, expName, keyName, defaultValue)
 }
 
 func GetInt64(id, expName, keyName string, defaultValue int64) (val int64) {
-	if client == nil {
-		logger.Error(ErrClientUninitialized)
-		return defaultValue
-	}
-
-	if err := client.GetKey(id, expName, keyName, &val); err != nil {
-		client.TrackError("GetInt64", id, expName, keyName, err)
-		val = defaultValue
-	}
-
-	return
+	return defaultClient.GetInt64(id, expName, keyName, defaultValue)
 }
 
 func GetFloat64(id, expName, keyName string, defaultValue float64) (val float64) {
-	if client == nil {
-		logger.Error(ErrClientUninitialized)
-		return float64(defaultValue)
-	}
-
-	if err := client.GetKey(id, expName, keyName, &val); err != nil {
-		client.TrackError("GetFloat64", id, expName, keyName, err)
-		val = float64(defaultValue)
-	}
-
-	return
+	return defaultClient.GetFloat64(id, expName, keyName, defaultValue)
 }
 
 func GetStringSlice(id, expName, keyName string, defaultValue []string) (val []string) {
-	if client == nil {
-		logger.Error(ErrClientUninitialized)
-		return defaultValue
-	}
-
-	if err := client.GetKey(id, expName, keyName, &val); err != nil {
-		client.TrackError("GetStringSlice", id, expName, keyName, err)
-		val = defaultValue
-	}
-
-	return
+	return defaultClient.GetStringSlice(id, expName, keyName, defaultValue)
 }
 
 func GetInt64Slice(id, expName, keyName string, defaultValue []int64) (val []int64) {
-	if client == nil {
-		logger.Error(ErrClientUninitialized)
-		return defaultValue
-	}
+	return defaultClient.GetInt64Slice(id, expName, keyName, defaultValue)
+}
 
-	if err := client.GetKey(id, expName, keyName, &val); err != nil {
-		client.TrackError("GetInt64Slice", id, expName, keyName, err)
-		val = defaultValue
-	}
+func GetMap(id, expName, keyName string, defaultValue map[string]interface{}) (val map[string]interface{}) {
+	return defaultClient.GetMap(id, expName, keyName, defaultValue)
+}
 
-	return
+// GetParam returns the value of keyName for id, whichever experiment sets it, see ABClient.GetParam.
+func GetParam(id, keyName string, defaultValue interface{}) (val interface{}) {
+	return defaultClient.GetParam(id, keyName, defaultValue)
 }
 
-func GetMap(id, expName, keyName string, defaultValue map[string]interface{}) (val map[string]interface{}) {
-	if client == nil {
-		logger.Error(ErrClientUninitialized)
-		return defaultValue
-	}
+func GetParamBool(id, keyName string, defaultValue bool) (val bool) {
+	return defaultClient.GetParamBool(id, keyName, defaultValue)
+}
 
-	if err := client.GetKey(id, expName, keyName, &val); err != nil {
-		client.TrackError("GetMap", id, expName, keyName, err)
-		val = defaultValue
-	}
+func GetParamString(id, keyName, defaultValue string) (val string) {
+	return defaultClient.GetParamString(id, keyName, defaultValue)
+}
 
-	return
+func GetParamInt64(id, keyName string, defaultValue int64) (val int64) {
+	return defaultClient.GetParamInt64(id, keyName, defaultValue)
 }
 
-func GetRawConfigs(expName string) (data map[string][]byte, err error) {
-	if client == nil {
-		logger.Error(ErrClientUninitialized)
-		return nil, ErrClientUninitialized
-	}
+func GetParamFloat64(id, keyName string, defaultValue float64) (val float64) {
+	return defaultClient.GetParamFloat64(id, keyName, defaultValue)
+}
 
-	data, err = client.GetRawConfigs(expName)
-	if err != nil {
-		client.TrackErrorNew("GetRawConfigs", "", expName, "", err)
-		return
-	}
-	return
+func GetRawConfigs(expName string) (data map[string][]byte, err error) {
+	return defaultClient.GetRawConfigs(expName)
 }
 
 func GetRawConfig(id, expName string) (data []byte, err error) {
-	if client == nil {
-		logger.Error(ErrClientUninitialized)
-		return nil, ErrClientUninitialized
-	}
-
-	data, err = client.GetRawConfig(id, expName)
-	if err != nil {
-		client.TrackErrorNew("GetRawConfigs", "", "", expName, err)
-		return
-	}
-	return
+	return defaultClient.GetRawConfig(id, expName)
 }
@@ -0,0 +1,163 @@
+package abtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// bindPlans caches the bindPlan of every struct type bound to, reflect.Type => *bindPlan.
+var bindPlans sync.Map
+
+// bindPlan tells how to bind a config to a struct type.
+type bindPlan struct {
+	fields []bindField
+	err    error // the tags are malformed
+}
+
+type bindField struct {
+	name       string // the name of the field, reported when defaulted
+	index      []int
+	key        string
+	hasDefault bool
+	defaultVal interface{} // decoded like a config value, so every bind gets a fresh copy
+}
+
+// GetExperimentInto binds the config of id in the experiment to dst, a pointer to a struct,
+// see GetExperimentIntoWithDefaults.
+func (c *ABClient) GetExperimentInto(id, expName string, dst interface{}) (err error) {
+	_, err = c.GetExperimentIntoWithDefaultsContext(context.Background(), id, expName, dst)
+	return
+}
+
+// GetExperimentIntoWithDefaults binds the config of id in the experiment to dst,
+// a pointer to a struct whose fields are tagged with the keys they read:
+//
+//	type RankerConfig struct {
+//		Model   string        `abtest:"model,default=v1"`
+//		Timeout time.Duration `abtest:"timeout,default=250ms"`
+//		Weights []float64     `abtest:"weights,default=[0.5,0.5]"`
+//		TopK    int           `abtest:"top_k"`
+//	}
+//
+// The default is any JSON value, or else a bare string.
+// A field whose key is missing or does not convert gets its default,
+// or keeps its value without a default, and is reported in defaulted.
+// Every field is bound even if an error is returned,
+// which is the first key that does not convert, or why the experiment is not served.
+// Untagged fields are left alone.
+func (c *ABClient) GetExperimentIntoWithDefaults(id, expName string, dst interface{}) (defaulted []string, err error) {
+	return c.GetExperimentIntoWithDefaultsContext(context.Background(), id, expName, dst)
+}
+
+// GetExperimentIntoWithDefaultsContext is like GetExperimentIntoWithDefaults, evaluating for the request in ctx, see ABClient.
+func (c *ABClient) GetExperimentIntoWithDefaultsContext(ctx context.Context, id, expName string, dst interface{}) (defaulted []string, err error) {
+	dstValue := reflect.ValueOf(dst)
+	if dstValue.Kind() != reflect.Ptr || dstValue.IsNil() || dstValue.Elem().Kind() != reflect.Struct {
+		err = fmt.Errorf("dst argument must be a pointer to a struct")
+		return
+	}
+
+	plan := getBindPlan(dstValue.Elem().Type())
+	if plan.err != nil {
+		err = plan.err
+		return
+	}
+
+	// An expired experiment serves the default strategy, still reporting ErrExperimentExpired.
+	config, lookupErr := c.lookup(ctx, id, expName)
+	if lookupErr != nil && lookupErr != ErrExperimentExpired {
+		config = emptyConfigView
+	}
+	defer func() {
+		if err == nil {
+			err = lookupErr
+		}
+	}()
+
+	elem := dstValue.Elem()
+	for _, field := range plan.fields {
+		fieldValue := elem.FieldByIndex(field.index)
+
+		val, keyErr := config.get(field.key)
+		if keyErr == nil {
+			keyErr = coerce(field.key, val, fieldValue)
+		}
+		if keyErr == nil {
+			continue
+		}
+
+		if !errors.Is(keyErr, ErrKeyNotFound) && err == nil {
+			err = keyErr
+		}
+		if field.hasDefault {
+			coerce(field.key, field.defaultVal, fieldValue)
+		}
+		defaulted = append(defaulted, field.name)
+	}
+
+	return
+}
+
+func getBindPlan(t reflect.Type) *bindPlan {
+	if plan, ok := bindPlans.Load(t); ok {
+		return plan.(*bindPlan)
+	}
+
+	plan, _ := bindPlans.LoadOrStore(t, newBindPlan(t))
+	return plan.(*bindPlan)
+}
+
+func newBindPlan(t reflect.Type) (plan *bindPlan) {
+	plan = new(bindPlan)
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("abtest")
+		if !ok || tag == "-" || len(f.PkgPath) > 0 {
+			continue
+		}
+
+		field := bindField{name: f.Name, index: f.Index, key: tag}
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			field.key = tag[:i]
+
+			// The default is the rest of the tag, so it may hold commas itself.
+			option := tag[i+1:]
+			if !strings.HasPrefix(option, "default=") {
+				plan.err = fmt.Errorf("field %s: unknown tag option %q", f.Name, option)
+				return
+			}
+
+			field.hasDefault = true
+			field.defaultVal = parseDefault(option[len("default="):])
+			if err := coerce(field.key, field.defaultVal, reflect.New(f.Type).Elem()); err != nil {
+				plan.err = fmt.Errorf("field %s: default: %v", f.Name, err)
+				return
+			}
+		}
+		if len(field.key) == 0 {
+			plan.err = fmt.Errorf("field %s: key is empty", f.Name)
+			return
+		}
+
+		plan.fields = append(plan.fields, field)
+	}
+
+	return
+}
+
+// parseDefault decodes a default in a tag like a config value, falling back to a bare string.
+func parseDefault(s string) (val interface{}) {
+	dec := json.NewDecoder(bytes.NewReader([]byte(s)))
+	dec.UseNumber()
+	if err := dec.Decode(&val); err != nil || dec.More() {
+		return s
+	}
+	return
+}
@@ -0,0 +1,454 @@
+package abtest
+
+import (
+	"context"
+
+	"github.com/phoenix-rec/abtest-sdk-go/abtest/consts"
+	logger "github.com/phoenix-rec/abtest-sdk-go/abtest/log"
+	proto "github.com/phoenix-rec/abtest-sdk-go/abtest/proto"
+)
+
+// Client is an A/B client bound to a single project.
+// Each Client owns its own configs and sync loop, so several clients
+// can be opened side by side in one process.
+type Client struct {
+	ab  *ABClient
+	ctx context.Context
+}
+
+// NewClient opens a Client which holds all the A/B configs of projectId in memory
+// and incrementally synchronizes data from server at intervals specified in opts.
+func NewClient(projectId int64, opts ...proto.Option) (c *Client, err error) {
+	if projectId == 0 {
+		err = ErrClientSettingErr
+		return
+	}
+
+	opt := proto.Options{
+		Hostport: consts.DefaultAbConfigHost,
+		Interval: consts.DefaultIntervalInSecond,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	if err = proto.CheckConflictPolicy(opt.ConflictPolicy); err != nil {
+		return
+	}
+
+	logger.InitDefaultLogger()
+	ab := &ABClient{options: opt}
+	ab.Open(ab, opt.Hostport, opt.Interval, projectId)
+
+	if opt.ReadyTimeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), opt.ReadyTimeout)
+		defer cancel()
+
+		if err = ab.WaitReady(ctx); err != nil {
+			ab.Close()
+			return
+		}
+	}
+
+	c = &Client{ab: ab}
+	return
+}
+
+// WithContext returns a view of c, sharing its configs and lifecycle, whose evaluations are
+// for the request in ctx, i.e. its targeting user and exposure scope, see ABClient.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	if c == nil {
+		return nil
+	}
+
+	return &Client{ab: c.ab, ctx: ctx}
+}
+
+// WithUser returns a view of c targeting the experiments with the attributes of user,
+// for the evaluations of user.Id.
+func (c *Client) WithUser(user *proto.User) *Client {
+	if c == nil {
+		return nil
+	}
+
+	return c.WithContext(proto.NewUserContext(c.context(), user))
+}
+
+func (c *Client) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+
+	return c.ctx
+}
+
+// IsReady reports whether the configs have been loaded from the config source.
+func (c *Client) IsReady() bool {
+	if c == nil {
+		return false
+	}
+
+	return c.ab.IsReady()
+}
+
+// WaitReady blocks until the configs are loaded from the config source for the first time,
+// retrying meanwhile. It returns a *ReadyTimeoutError if ctx is done first.
+func (c *Client) WaitReady(ctx context.Context) error {
+	if c == nil {
+		return ErrClientUninitialized
+	}
+
+	return c.ab.WaitReady(ctx)
+}
+
+func (c *Client) Close() {
+	if c == nil {
+		return
+	}
+
+	c.ab.Close()
+	return
+}
+
+// Status reports the sync state of the client.
+func (c *Client) Status() Status {
+	if c == nil {
+		return Status{LastError: ErrClientUninitialized}
+	}
+
+	return c.ab.Status()
+}
+
+// OnChange calls fn after every update which changes the configs.
+// The returned function unregisters fn.
+func (c *Client) OnChange(fn func(ChangeEvent)) (cancel func()) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return func() {}
+	}
+
+	return c.ab.OnChange(fn)
+}
+
+// Watch returns a channel receiving the changes of expName.
+// The returned function stops the watch and closes the channel.
+func (c *Client) Watch(expName string) (ch <-chan ChangeEvent, cancel func()) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		closed := make(chan ChangeEvent)
+		close(closed)
+		return closed, func() {}
+	}
+
+	return c.ab.Watch(expName)
+}
+
+func (c *Client) GetConfig(id string) (config map[string]interface{}) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return make(map[string]interface{})
+	}
+
+	config, err := c.ab.GetConfigContext(c.context(), id)
+	if err != nil {
+		c.ab.TrackError("GetConfig", id, "", "", err)
+	}
+
+	return
+}
+
+// GetConfigWithProvenance is like GetConfig, also returning provenance, key => the experiment the value is from.
+func (c *Client) GetConfigWithProvenance(id string) (config map[string]interface{}, provenance map[string]string) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return make(map[string]interface{}), make(map[string]string)
+	}
+
+	config, provenance, err := c.ab.GetConfigWithProvenanceContext(c.context(), id)
+	if err != nil {
+		c.ab.TrackError("GetConfigWithProvenance", id, "", "", err)
+	}
+
+	return
+}
+
+func (c *Client) GetExperiments(id string) (experiments map[string]map[string]interface{}) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return make(map[string]map[string]interface{})
+	}
+
+	experiments, err := c.ab.GetExperimentsContext(c.context(), id)
+	if err != nil {
+		c.ab.TrackError("GetExperiments", id, "", "", err)
+	}
+
+	return
+}
+
+func (c *Client) GetExperiment(id, expName string) (exp map[string]interface{}) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return make(map[string]interface{})
+	}
+
+	exp, err := c.ab.GetExperimentContext(c.context(), id, expName)
+	if err != nil {
+		c.ab.TrackError("GetExperiment", id, expName, "", err)
+	}
+
+	return
+}
+
+func (c *Client) GetStrategyNamesByExpName(expName string) (strategies []string, err error) {
+	if c == nil {
+		err = ErrClientUninitialized
+		return
+	}
+	return c.ab.GetStrategyNamesByExpName(expName)
+}
+
+func (c *Client) GetStrategyName(id, expName string) (strategy string, err error) {
+	if c == nil {
+		err = ErrClientUninitialized
+		return
+	}
+	return c.ab.GetStrategyNameContext(c.context(), id, expName)
+}
+
+// GetStrategyDetail tells which strategy id is served in the experiment and why.
+func (c *Client) GetStrategyDetail(id, expName string) (detail proto.EvaluationDetail, err error) {
+	if c == nil {
+		err = ErrClientUninitialized
+		return
+	}
+	return c.ab.GetStrategyDetailContext(c.context(), id, expName)
+}
+
+// GetLayerDetail tells which experiment of the layer id falls into, and its strategy there.
+func (c *Client) GetLayerDetail(id, domain, layer string) (expName string, detail proto.EvaluationDetail, err error) {
+	if c == nil {
+		err = ErrClientUninitialized
+		return
+	}
+	return c.ab.GetLayerDetailContext(c.context(), id, domain, layer)
+}
+
+func (c *Client) GetBool(id, expName, keyName string, defaultValue bool) (val bool) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return defaultValue
+	}
+
+	val, err := c.ab.getBool(c.context(), id, expName, keyName)
+	if err != nil && err != ErrExperimentExpired {
+		c.ab.TrackError("GetBool", id, expName, keyName, err)
+		val = defaultValue
+	}
+
+	return
+}
+
+func (c *Client) GetString(id, expName, keyName, defaultValue string) (val string) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return defaultValue
+	}
+
+	val, err := c.ab.getString(c.context(), id, expName, keyName)
+	if err != nil && err != ErrExperimentExpired {
+		c.ab.TrackError("GetString", id, expName, keyName, err)
+		val = defaultValue
+	}
+
+	return
+}
+
+func (c *Client) GetInt64(id, expName, keyName string, defaultValue int64) (val int64) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return defaultValue
+	}
+
+	val, err := c.ab.getInt64(c.context(), id, expName, keyName)
+	if err != nil && err != ErrExperimentExpired {
+		c.ab.TrackError("GetInt64", id, expName, keyName, err)
+		val = defaultValue
+	}
+
+	return
+}
+
+func (c *Client) GetFloat64(id, expName, keyName string, defaultValue float64) (val float64) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return defaultValue
+	}
+
+	val, err := c.ab.getFloat64(c.context(), id, expName, keyName)
+	if err != nil && err != ErrExperimentExpired {
+		c.ab.TrackError("GetFloat64", id, expName, keyName, err)
+		val = defaultValue
+	}
+
+	return
+}
+
+func (c *Client) GetStringSlice(id, expName, keyName string, defaultValue []string) (val []string) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return defaultValue
+	}
+
+	if err := c.ab.GetKeyContext(c.context(), id, expName, keyName, &val); err != nil && err != ErrExperimentExpired {
+		c.ab.TrackError("GetStringSlice", id, expName, keyName, err)
+		val = defaultValue
+	}
+
+	return
+}
+
+func (c *Client) GetInt64Slice(id, expName, keyName string, defaultValue []int64) (val []int64) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return defaultValue
+	}
+
+	if err := c.ab.GetKeyContext(c.context(), id, expName, keyName, &val); err != nil && err != ErrExperimentExpired {
+		c.ab.TrackError("GetInt64Slice", id, expName, keyName, err)
+		val = defaultValue
+	}
+
+	return
+}
+
+func (c *Client) GetMap(id, expName, keyName string, defaultValue map[string]interface{}) (val map[string]interface{}) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return defaultValue
+	}
+
+	if err := c.ab.GetKeyContext(c.context(), id, expName, keyName, &val); err != nil && err != ErrExperimentExpired {
+		c.ab.TrackError("GetMap", id, expName, keyName, err)
+		val = defaultValue
+	}
+
+	return
+}
+
+// GetParam returns the value of keyName for id, whichever experiment sets it, see ABClient.GetParam.
+func (c *Client) GetParam(id, keyName string, defaultValue interface{}) (val interface{}) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return defaultValue
+	}
+
+	if _, err := c.ab.GetParamContext(c.context(), id, keyName, &val); err != nil && err != ErrExperimentExpired {
+		c.ab.TrackError("GetParam", id, "", keyName, err)
+		val = defaultValue
+	}
+
+	return
+}
+
+func (c *Client) GetParamBool(id, keyName string, defaultValue bool) (val bool) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return defaultValue
+	}
+
+	val, err := c.ab.getParamBool(c.context(), id, keyName)
+	if err != nil && err != ErrExperimentExpired {
+		c.ab.TrackError("GetParamBool", id, "", keyName, err)
+		val = defaultValue
+	}
+
+	return
+}
+
+func (c *Client) GetParamString(id, keyName, defaultValue string) (val string) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return defaultValue
+	}
+
+	val, err := c.ab.getParamString(c.context(), id, keyName)
+	if err != nil && err != ErrExperimentExpired {
+		c.ab.TrackError("GetParamString", id, "", keyName, err)
+		val = defaultValue
+	}
+
+	return
+}
+
+func (c *Client) GetParamInt64(id, keyName string, defaultValue int64) (val int64) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return defaultValue
+	}
+
+	val, err := c.ab.getParamInt64(c.context(), id, keyName)
+	if err != nil && err != ErrExperimentExpired {
+		c.ab.TrackError("GetParamInt64", id, "", keyName, err)
+		val = defaultValue
+	}
+
+	return
+}
+
+func (c *Client) GetParamFloat64(id, keyName string, defaultValue float64) (val float64) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return defaultValue
+	}
+
+	val, err := c.ab.getParamFloat64(c.context(), id, keyName)
+	if err != nil && err != ErrExperimentExpired {
+		c.ab.TrackError("GetParamFloat64", id, "", keyName, err)
+		val = defaultValue
+	}
+
+	return
+}
+
+// GetExperimentInto binds the config of id in the experiment to dst, see ABClient.GetExperimentIntoWithDefaults.
+func (c *Client) GetExperimentInto(id, expName string, dst interface{}) (err error) {
+	_, err = c.GetExperimentIntoWithDefaults(id, expName, dst)
+	return
+}
+
+// GetExperimentIntoWithDefaults is like GetExperimentInto, also reporting the fields defaulted.
+func (c *Client) GetExperimentIntoWithDefaults(id, expName string, dst interface{}) (defaulted []string, err error) {
+	if c == nil {
+		err = ErrClientUninitialized
+		return
+	}
+	return c.ab.GetExperimentIntoWithDefaultsContext(c.context(), id, expName, dst)
+}
+
+func (c *Client) GetRawConfigs(expName string) (data map[string][]byte, err error) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return nil, ErrClientUninitialized
+	}
+
+	data, err = c.ab.GetRawConfigs(expName)
+	if err != nil {
+		c.ab.TrackErrorNew("GetRawConfigs", "", expName, "", err)
+		return
+	}
+	return
+}
+
+func (c *Client) GetRawConfig(id, expName string) (data []byte, err error) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return nil, ErrClientUninitialized
+	}
+
+	data, err = c.ab.GetRawConfig(id, expName)
+	if err != nil {
+		c.ab.TrackErrorNew("GetRawConfigs", "", "", expName, err)
+		return
+	}
+	return
+}
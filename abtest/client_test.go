@@ -0,0 +1,1036 @@
+package abtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/phoenix-rec/abtest-sdk-go/abtest/exposure"
+	proto "github.com/phoenix-rec/abtest-sdk-go/abtest/proto"
+)
+
+// newTestServer serves configs the way the A/B server does.
+func newTestServer(t *testing.T, data map[int64][]map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ret": 1,
+			"data": map[string]interface{}{
+				"time":            1,
+				"config_list_map": data,
+			},
+		})
+	}))
+}
+
+func testExperiment(name string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"exp_id":          name + "_id",
+		"name":            name,
+		"exp_type":        1,
+		"ut":              1,
+		"status":          1,
+		"expire":          0,
+		"partition_count": 100,
+		"partitions_map":  map[string]string{"treat": "0-99"},
+		"config_map": map[string]interface{}{
+			"default": map[string]interface{}{"key": 0},
+			"treat":   map[string]interface{}{"key": value},
+		},
+	}
+}
+
+func TestNewClientIsolated(t *testing.T) {
+	server := newTestServer(t, map[int64][]map[string]interface{}{
+		1: {testExperiment("exp", 1)},
+		2: {testExperiment("exp", 2)},
+	})
+	defer server.Close()
+
+	c1, err := NewClient(1, proto.WithHostport(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+
+	c2, err := NewClient(2, proto.WithHostport(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	if v := c1.GetInt64("u1", "exp", "key", -1); v != 1 {
+		t.Errorf("c1 got %d, want 1", v)
+	}
+	if v := c2.GetInt64("u1", "exp", "key", -1); v != 2 {
+		t.Errorf("c2 got %d, want 2", v)
+	}
+
+	if _, err := NewClient(0); err != ErrClientSettingErr {
+		t.Errorf("got %v, want %v", err, ErrClientSettingErr)
+	}
+}
+
+func TestNilClient(t *testing.T) {
+	var c *Client
+	if v := c.GetString("u1", "exp", "key", "def"); v != "def" {
+		t.Errorf("got %s, want def", v)
+	}
+	if _, err := c.GetStrategyName("u1", "exp"); err != ErrClientUninitialized {
+		t.Errorf("got %v, want %v", err, ErrClientUninitialized)
+	}
+	c.Close()
+}
+
+func TestCloseConcurrent(t *testing.T) {
+	c, err := NewClient(1, proto.WithConfigSource(NewMemorySource(testConfigList(t, 1, 1, testExperiment("exp", 1)))), proto.WithInterval(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c.GetInt64("u1", "exp", "key", -1)
+			}
+		}()
+	}
+	c.Close()
+	c.Close()
+	wg.Wait()
+
+	if _, err := c.ab.GetExperiment("u1", "exp"); err != ErrClientStopped {
+		t.Errorf("got %v, want %v", err, ErrClientStopped)
+	}
+}
+
+func TestSnapshotLatestWins(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "abtest.snapshot")
+	c, err := NewClient(1, proto.WithConfigSource(NewMemorySource(testConfigList(t, 1, 1, testExperiment("exp", 1)))), proto.WithSnapshotPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var ut int64 = 1
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				v := atomic.AddInt64(&ut, 1)
+				c.ab.apply(testConfigList(t, v, 1, testExperiment("exp", v)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := loadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version := c.Status().Version; data.Time != version {
+		t.Errorf("got snapshot of version %d, want the latest %d", data.Time, version)
+	}
+}
+
+func TestSnapshotColdStart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "abtest.snapshot")
+	server := newTestServer(t, map[int64][]map[string]interface{}{
+		1: {testExperiment("exp", 7)},
+	})
+
+	c1, err := NewClient(1, proto.WithHostport(server.URL), proto.WithSnapshotPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1.Close()
+	server.Close()
+
+	c2, err := NewClient(1, proto.WithHostport(server.URL), proto.WithSnapshotPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	if v := c2.GetInt64("u1", "exp", "key", -1); v != 7 {
+		t.Errorf("got %d, want 7", v)
+	}
+}
+
+func TestExpiredExperiment(t *testing.T) {
+	exp := testExperiment("exp", 1)
+	exp["expire"] = time.Now().Add(-time.Hour).Unix()
+	c, err := NewClient(1, proto.WithConfigSource(NewMemorySource(testConfigList(t, 1, 1, exp))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	strategy, err := c.GetStrategyName("u1", "exp")
+	if strategy != "default" || err != ErrExperimentExpired {
+		t.Errorf("got (%s, %v), want (default, %v)", strategy, err, ErrExperimentExpired)
+	}
+
+	// Served from the default strategy rather than the code default.
+	if v := c.GetInt64("u1", "exp", "key", -1); v != 0 {
+		t.Errorf("got %d, want 0", v)
+	}
+}
+
+func TestReadyTimeout(t *testing.T) {
+	server := newTestServer(t, nil)
+	server.Close()
+
+	_, err := NewClient(1, proto.WithHostport(server.URL), proto.WithReadyTimeout(100*time.Millisecond))
+	var timeoutErr *ReadyTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("got %v, want *ReadyTimeoutError", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || timeoutErr.LastErr == nil {
+		t.Errorf("unexpected err %v", err)
+	}
+
+	c, err := NewClient(1, proto.WithConfigSource(NewMemorySource(testConfigList(t, 1, 1))), proto.WithReadyTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if !c.IsReady() {
+		t.Error("client not ready")
+	}
+}
+
+func TestWaitReadyRetries(t *testing.T) {
+	source := &countingSource{}
+	c, err := NewClient(1, proto.WithConfigSource(source), proto.WithInterval(60))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3500*time.Millisecond)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.WaitReady(ctx)
+		}()
+	}
+	wg.Wait()
+
+	// Open, then the waiters together after 1s and 2s more, not every waiter every second.
+	if n := atomic.LoadInt32(&source.calls); n > 3 {
+		t.Errorf("got %d calls, want at most 3", n)
+	}
+
+	stopped := make(chan error)
+	go func() { stopped <- c.WaitReady(context.Background()) }()
+	c.Close()
+	select {
+	case err := <-stopped:
+		if err != ErrClientStopped {
+			t.Errorf("got %v, want %v", err, ErrClientStopped)
+		}
+	case <-time.After(time.Second):
+		t.Error("WaitReady still blocked after Close")
+	}
+}
+
+type countingSource struct {
+	calls int32
+}
+
+func (s *countingSource) GetConfigList(ut int64) (*proto.GetConfigListData, error) {
+	atomic.AddInt32(&s.calls, 1)
+	return nil, errors.New("unavailable")
+}
+
+type failingSource struct{}
+
+func (failingSource) GetConfigList(ut int64) (*proto.GetConfigListData, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestStatus(t *testing.T) {
+	c, err := NewClient(1, proto.WithConfigSource(NewMemorySource(testConfigList(t, 5, 1, testExperiment("a", 1), testExperiment("b", 1)))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	status := c.Status()
+	if !status.Ready || status.Version != 5 || status.Experiments[1] != 2 || status.ConsecutiveFailures != 0 || status.LastSyncTime.IsZero() {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	c2, err := NewClient(1, proto.WithConfigSource(failingSource{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	c2.ab.Update()
+
+	status = c2.Status()
+	if status.Ready || status.ConsecutiveFailures != 2 || status.LastError == nil || !status.LastSyncTime.IsZero() || status.NextRetryTime.IsZero() {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+type recordingLogger struct {
+	m       sync.Mutex
+	records []exposure.Record
+}
+
+func (l *recordingLogger) Log(r exposure.Record) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.records = append(l.records, r)
+}
+
+func (l *recordingLogger) Close() error {
+	return nil
+}
+
+func TestExposureLogging(t *testing.T) {
+	l := new(recordingLogger)
+	c, err := NewClient(1, proto.WithConfigSource(NewMemorySource(testConfigList(t, 1, 1, testExperiment("exp", 1)))), proto.WithExposureLogger(l))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.GetInt64("u1", "exp", "key", -1)
+	c.GetStrategyName("u2", "exp")
+	c.GetInt64("u1", "missing", "key", -1)
+
+	if len(l.records) != 2 {
+		t.Fatalf("got %d records, want 2", len(l.records))
+	}
+	r := l.records[0]
+	if r.ProjectId != 1 || r.ExpName != "exp" || r.ExpID != "exp_id" || r.Strategy != "treat" || r.Id != "u1" || r.Reason != "BUCKETED" || r.Timestamp == 0 {
+		t.Errorf("unexpected record %+v", r)
+	}
+}
+
+func TestExposureAssignedOnly(t *testing.T) {
+	targeted := testExperiment("targeted", 1)
+	targeted["targeting"] = map[string]interface{}{"attr": "country", "op": "eq", "value": "us"}
+	unallocated := testExperiment("unallocated", 1)
+	unallocated["partitions_map"] = map[string]string{}
+	list := testConfigList(t, 1, 1, targeted, unallocated)
+
+	l := new(recordingLogger)
+	c, err := NewClient(1, proto.WithConfigSource(NewMemorySource(list)), proto.WithExposureLogger(l))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	user := c.WithUser(&proto.User{Id: "u1", Attributes: map[string]interface{}{"country": "fr"}})
+	user.GetInt64("u1", "targeted", "key", -1)
+	user.GetInt64("u1", "unallocated", "key", -1)
+	if len(l.records) != 0 {
+		t.Errorf("got exposures %+v of ids outside of the experiments", l.records)
+	}
+
+	l = new(recordingLogger)
+	all, err := NewClient(1, proto.WithConfigSource(NewMemorySource(list)), proto.WithExposureLogger(l),
+		proto.WithExposureReasons(proto.ReasonBucketed, proto.ReasonDefaultUnallocated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer all.Close()
+
+	all.GetInt64("u1", "unallocated", "key", -1)
+	if len(l.records) != 1 || l.records[0].Reason != proto.ReasonDefaultUnallocated {
+		t.Errorf("got %+v, want the unallocated exposure", l.records)
+	}
+}
+
+func TestExposureRequestScope(t *testing.T) {
+	l := new(recordingLogger)
+	c, err := NewClient(1, proto.WithConfigSource(NewMemorySource(testConfigList(t, 1, 1, testExperiment("exp", 1)))),
+		proto.WithExposureLogger(l), proto.WithExposureFilter(exposure.NewFilter()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	rc := c.WithContext(exposure.NewContext(context.Background()))
+	rc.GetInt64("u1", "exp", "key", -1)
+	rc.GetBool("u1", "exp", "key", false)
+	rc.GetConfig("u1")
+
+	if len(l.records) != 1 {
+		t.Errorf("got %d records, want 1", len(l.records))
+	}
+}
+
+func TestStrategyDetail(t *testing.T) {
+	half := testExperiment("half", 1)
+	half["partitions_map"] = map[string]string{"treat": "0-49"}
+	half["white_map"] = map[string]string{"vip": "treat"}
+	disabled := testExperiment("disabled", 1)
+	disabled["status"] = -1
+	expired := testExperiment("expired", 1)
+	expired["expire"] = 1
+	c, err := NewClient(1, proto.WithConfigSource(NewMemorySource(testConfigList(t, 1, 1, half, disabled, expired))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	detail, err := c.GetStrategyDetail("vip", "half")
+	if err != nil || detail.Reason != proto.ReasonWhitelist || detail.Strategy != "treat" || detail.Partition != -1 {
+		t.Errorf("whitelist: got %+v, %v", detail, err)
+	}
+
+	reasons := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		detail, err := c.GetStrategyDetail(fmt.Sprint(i), "half")
+		if err != nil || detail.ExpID != "half_id" || detail.Ut != 1 {
+			t.Fatalf("got %+v, %v", detail, err)
+		}
+		switch {
+		case detail.Partition < 50 && detail.Reason == proto.ReasonBucketed && detail.Strategy == "treat":
+		case detail.Partition >= 50 && detail.Reason == proto.ReasonDefaultUnallocated && detail.Strategy == "default":
+		default:
+			t.Fatalf("unexpected detail %+v", detail)
+		}
+		reasons[detail.Reason] = true
+	}
+	if len(reasons) != 2 {
+		t.Errorf("got reasons %v", reasons)
+	}
+
+	if detail, _ := c.GetStrategyDetail("u1", "disabled"); detail.Reason != proto.ReasonDisabled || detail.Strategy != "default" {
+		t.Errorf("disabled: got %+v", detail)
+	}
+	// GetStrategyName keeps serving the hashed strategy of a disabled experiment.
+	if strategyName, err := c.GetStrategyName("u1", "disabled"); err != nil || strategyName != "treat" {
+		t.Errorf("disabled: got strategy %s, %v, want treat", strategyName, err)
+	}
+	if detail, err := c.GetStrategyDetail("u1", "expired"); detail.Reason != proto.ReasonExpired || err != ErrExperimentExpired {
+		t.Errorf("expired: got %+v, %v", detail, err)
+	}
+	if detail, err := c.GetStrategyDetail("u1", "missing"); detail.Reason != proto.ReasonError || err != ErrExperimentNotFound {
+		t.Errorf("missing: got %+v, %v", detail, err)
+	}
+}
+
+func TestStickyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sticky.jsonl")
+	store, err := NewFileStickyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := testExperiment("exp", 1)
+	exp["sticky"] = true
+	source := NewMemorySource(testConfigList(t, 1, 1, exp))
+	c, err := NewClient(1, proto.WithConfigSource(source), proto.WithStickyStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if detail, _ := c.GetStrategyDetail("u1", "exp"); detail.Reason != proto.ReasonBucketed || detail.Strategy != "treat" {
+		t.Fatalf("got %+v", detail)
+	}
+
+	// Reallocate all of the partitions to a new strategy.
+	exp = testExperiment("exp", 1)
+	exp["sticky"], exp["ut"] = true, 2
+	exp["partitions_map"] = map[string]string{"control": "0-99"}
+	source.Set(testConfigList(t, 2, 1, exp))
+	if err := c.ab.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	if detail, _ := c.GetStrategyDetail("u1", "exp"); detail.Reason != proto.ReasonSticky || detail.Strategy != "treat" {
+		t.Errorf("got %+v, want sticky treat", detail)
+	}
+	if detail, _ := c.GetStrategyDetail("u2", "exp"); detail.Reason != proto.ReasonBucketed || detail.Strategy != "control" {
+		t.Errorf("got %+v, want bucketed control", detail)
+	}
+
+	store.Close()
+	store, err = NewFileStickyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if strategyName, ok := store.Get("u1", "exp_id"); !ok || strategyName != "treat" {
+		t.Errorf("got %s, %v after reopen", strategyName, ok)
+	}
+
+	lru := NewMemoryStickyStore(1)
+	lru.Put("u1", "exp_id", "a")
+	lru.Put("u2", "exp_id", "b")
+	if _, ok := lru.Get("u1", "exp_id"); ok {
+		t.Error("u1 not evicted")
+	}
+
+	unbounded := NewMemoryStickyStore(0)
+	unbounded.Put("u1", "exp_id", "a")
+	if _, ok := unbounded.Get("u1", "exp_id"); !ok {
+		t.Error("u1 evicted without a size")
+	}
+}
+
+func TestFileStickyStoreCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sticky.jsonl")
+	store, err := NewFileStickyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strategies := []string{"a", "b"}
+	last := make(map[string]string)
+	for i := 0; i < 2*stickyCompactMinLines; i++ {
+		id := fmt.Sprintf("u%d", i%10)
+		last[id] = strategies[i/10%2]
+		store.Put(id, "exp_id", last[id])
+		if i%100 == 0 {
+			store.flush()
+		}
+	}
+	store.flush()
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(bs, []byte("\n")); lines >= stickyCompactMinLines {
+		t.Errorf("got %d lines, want the file compacted", lines)
+	}
+
+	store.Put("u0", "exp_id", "c")
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != os.ErrClosed {
+		t.Errorf("got %v closing twice", err)
+	}
+
+	store, err = NewFileStickyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if len(store.entries) != 10 {
+		t.Errorf("got %d entries after reopen, want 10", len(store.entries))
+	}
+	if strategyName, _ := store.Get("u0", "exp_id"); strategyName != "c" {
+		t.Errorf("got %s, want c written on close", strategyName)
+	}
+	if strategyName, _ := store.Get("u9", "exp_id"); strategyName != last["u9"] {
+		t.Errorf("got %s for u9, want %s", strategyName, last["u9"])
+	}
+}
+
+func TestFileStickyStoreTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sticky.jsonl")
+	if err := os.WriteFile(path, []byte(`{"id":"u1","exp_id":"exp_id","strategy":"a"}`+"\n"+`{"id":"u2","exp_`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewFileStickyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strategyName, _ := store.Get("u1", "exp_id"); strategyName != "a" || len(store.entries) != 1 || store.lines != 1 {
+		t.Errorf("got %s, %d entries and %d lines, want the torn line dropped", strategyName, len(store.entries), store.lines)
+	}
+
+	// A failed write keeps the assignment pending.
+	store.fm.Lock()
+	f := store.f
+	store.f = nil
+	store.fm.Unlock()
+	store.Put("u2", "exp_id", "b")
+	store.flush()
+	if len(store.pending) != 1 || store.lines != 1 {
+		t.Errorf("got %d pending and %d lines after a failed write, want 1 and 1", len(store.pending), store.lines)
+	}
+
+	store.fm.Lock()
+	store.f = f
+	store.fm.Unlock()
+	store.Put("u3", "exp_id", "c")
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewFileStickyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for id, want := range map[string]string{"u1": "a", "u2": "b", "u3": "c"} {
+		if strategyName, _ := store.Get(id, "exp_id"); strategyName != want {
+			t.Errorf("got %s for %s after reopen, want %s", strategyName, id, want)
+		}
+	}
+}
+
+func TestLayers(t *testing.T) {
+	layered := func(name, layer, slots string) map[string]interface{} {
+		exp := testExperiment(name, 1)
+		exp["domain"], exp["layer"], exp["layer_slots"] = "rec", layer, slots
+		return exp
+	}
+	source := NewMemorySource(testConfigList(t, 1, 1,
+		layered("recall_a", "recall", "0-49"),
+		layered("recall_b", "recall", "50-99"),
+		layered("rank", "rank", "0-99"),
+	))
+	c, err := NewClient(1, proto.WithConfigSource(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var inA, inRank int
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("u%d", i)
+		a, _ := c.GetStrategyDetail(id, "recall_a")
+		b, _ := c.GetStrategyDetail(id, "recall_b")
+		if (a.Reason == proto.ReasonBucketed) == (b.Reason == proto.ReasonBucketed) {
+			t.Fatalf("%s: recall_a %s, recall_b %s", id, a.Reason, b.Reason)
+		}
+
+		expName, detail, err := c.GetLayerDetail(id, "rec", "recall")
+		if err != nil || detail.Reason != proto.ReasonBucketed || (expName == "recall_a") != (a.Reason == proto.ReasonBucketed) {
+			t.Fatalf("%s: got %s %+v %v", id, expName, detail, err)
+		}
+
+		if rank, _ := c.GetStrategyDetail(id, "rank"); rank.Reason != proto.ReasonBucketed {
+			t.Fatalf("%s: rank %s", id, rank.Reason)
+		}
+		if a.Reason == proto.ReasonBucketed {
+			inA++
+		}
+		inRank++
+	}
+	if inA < 400 || inA > 600 {
+		t.Errorf("%d of %d in recall_a", inA, inRank)
+	}
+
+	if _, _, err := c.GetLayerDetail("u1", "rec", "rerank"); err != ErrLayerNotFound {
+		t.Errorf("got %v, want ErrLayerNotFound", err)
+	}
+}
+
+func TestTargeting(t *testing.T) {
+	exp := testExperiment("exp", 1)
+	exp["targeting"] = map[string]interface{}{"all": []map[string]interface{}{
+		{"attr": "platform", "op": "in", "value": []string{"android"}},
+		{"attr": "app_version", "op": "gte", "value": 5.2},
+	}}
+	c, err := NewClient(1, proto.WithConfigSource(NewMemorySource(testConfigList(t, 1, 1, exp))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, test := range []struct {
+		attrs  map[string]interface{}
+		reason string
+	}{
+		{map[string]interface{}{"platform": "android", "app_version": 5.2}, proto.ReasonBucketed},
+		{map[string]interface{}{"platform": "android", "app_version": 6}, proto.ReasonBucketed},
+		{map[string]interface{}{"platform": "android", "app_version": 5.1}, proto.ReasonNotTargeted},
+		{map[string]interface{}{"platform": "ios", "app_version": 6}, proto.ReasonNotTargeted},
+		{nil, proto.ReasonNotTargeted},
+	} {
+		user := c.WithUser(&proto.User{Id: "u1", Attributes: test.attrs})
+		if detail, _ := user.GetStrategyDetail("u1", "exp"); detail.Reason != test.reason {
+			t.Errorf("%v: got %s, want %s", test.attrs, detail.Reason, test.reason)
+		}
+		if v := user.GetInt64("u1", "exp", "key", -1); (v == 1) != (test.reason == proto.ReasonBucketed) {
+			t.Errorf("%v: got %d", test.attrs, v)
+		}
+	}
+}
+
+func TestGetInt64Allocs(t *testing.T) {
+	c, err := NewClient(1, proto.WithConfigSource(NewMemorySource(testConfigList(t, 1, 1, testExperiment("exp", 7)))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if v := c.GetInt64("u1", "exp", "key", -1); v != 7 {
+		t.Fatalf("got %d, want 7", v)
+	}
+	if n := testing.AllocsPerRun(100, func() { c.GetInt64("u1", "exp", "key", -1) }); n != 0 {
+		t.Errorf("GetInt64: %v allocs", n)
+	}
+
+	// Values not decoded ahead of time still go through GetKey.
+	if v := c.GetFloat64("u1", "exp", "key", -1); v != 7 {
+		t.Errorf("GetFloat64 got %v, want 7", v)
+	}
+	if v := c.GetString("u1", "exp", "key", "none"); v != "none" {
+		t.Errorf("GetString got %s, want none", v)
+	}
+}
+
+func BenchmarkGetInt64(b *testing.B) {
+	bs, _ := json.Marshal(map[string]interface{}{
+		"time":            1,
+		"config_list_map": map[int64][]map[string]interface{}{1: {testExperiment("exp", 7)}},
+	})
+	data := new(proto.GetConfigListData)
+	if err := json.Unmarshal(bs, data); err != nil {
+		b.Fatal(err)
+	}
+	c, err := NewClient(1, proto.WithConfigSource(NewMemorySource(data)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c.Close()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.GetInt64("u1", "exp", "key", -1)
+	}
+}
+
+func TestGenericGetters(t *testing.T) {
+	exp := testExperiment("exp", 1)
+	exp["config_map"] = map[string]interface{}{
+		"default": map[string]interface{}{"timeout": 100, "weights": []float64{0.5}},
+		"treat":   map[string]interface{}{"timeout": 250, "weights": []float64{0.3, 0.7}},
+	}
+	source := NewMemorySource(testConfigList(t, 1, 1, exp))
+	c, err := NewClient(1, proto.WithConfigSource(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if v := GetFrom(c, "u1", "exp", "timeout", time.Duration(-1)); v != 250 {
+		t.Errorf("got %v, want 250", v)
+	}
+	if v := GetFrom(c, "u1", "exp", "weights", []float64(nil)); !reflect.DeepEqual(v, []float64{0.3, 0.7}) {
+		t.Errorf("got %v", v)
+	}
+	if v := GetFrom(c, "u1", "exp", "weights", "none"); v != "none" {
+		t.Errorf("got %v, want the default on mismatch", v)
+	}
+
+	timeout := NewFlag("exp", "timeout", int64(-1))
+	missing := NewFlag("exp", "missing", int64(-1))
+	if v := timeout.GetFrom(c, "u1"); v != 250 {
+		t.Errorf("flag got %d, want 250", v)
+	}
+	if v := missing.GetFrom(c, "u1"); v != -1 {
+		t.Errorf("missing flag got %d, want -1", v)
+	}
+	if n := testing.AllocsPerRun(100, func() { timeout.GetFrom(c, "u1") }); n != 0 {
+		t.Errorf("Flag.GetFrom: %v allocs", n)
+	}
+
+	// A new version of the configs refreshes the flag.
+	exp["ut"] = 2
+	exp["partitions_map"] = map[string]string{}
+	source.Set(testConfigList(t, 2, 1, exp))
+	if err := c.ab.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if v := timeout.GetFrom(c, "u1"); v != 100 {
+		t.Errorf("flag got %d after update, want 100", v)
+	}
+}
+
+func TestGetExperimentInto(t *testing.T) {
+	exp := testExperiment("exp", 1)
+	exp["config_map"] = map[string]interface{}{
+		"default": map[string]interface{}{},
+		"treat": map[string]interface{}{
+			"model":   "v2",
+			"timeout": "100ms",
+			"top_k":   "ten",
+		},
+	}
+	c, err := NewClient(1, proto.WithConfigSource(NewMemorySource(testConfigList(t, 1, 1, exp))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	type rankerConfig struct {
+		Model   string        `abtest:"model,default=v1"`
+		Timeout time.Duration `abtest:"timeout,default=250ms"`
+		Weights []float64     `abtest:"weights,default=[0.5,0.5]"`
+		TopK    int           `abtest:"top_k,default=20"`
+		Extra   int           `abtest:"extra"`
+		Ignored int
+	}
+
+	cfg := rankerConfig{Extra: 3, Ignored: 4}
+	defaulted, err := c.GetExperimentIntoWithDefaults("u1", "exp", &cfg)
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("got %v, want ErrTypeMismatch for top_k", err)
+	}
+	want := rankerConfig{Model: "v2", Timeout: 100 * time.Millisecond, Weights: []float64{0.5, 0.5}, TopK: 20, Extra: 3, Ignored: 4}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("got %+v, want %+v", cfg, want)
+	}
+	if !reflect.DeepEqual(defaulted, []string{"Weights", "TopK", "Extra"}) {
+		t.Errorf("got defaulted %v", defaulted)
+	}
+
+	cfg = rankerConfig{}
+	if err := c.GetExperimentInto("u1", "missing", &cfg); err != ErrExperimentNotFound || cfg.Model != "v1" {
+		t.Errorf("got %+v, %v", cfg, err)
+	}
+
+	var bad struct {
+		N int `abtest:"n,default=x"`
+	}
+	if err := c.GetExperimentInto("u1", "exp", &bad); err == nil {
+		t.Error("bad default: no error")
+	}
+}
+
+func TestInheritDefault(t *testing.T) {
+	exp := testExperiment("exp", 1)
+	exp["config_map"] = map[string]interface{}{
+		"default": map[string]interface{}{"model": "v1", "ranker": map[string]interface{}{"ctr": 0.5, "cvr": 0.5}},
+		"treat":   map[string]interface{}{"ranker": map[string]interface{}{"ctr": 0.3}},
+	}
+
+	source := NewMemorySource(testConfigList(t, 1, 1, exp))
+	c, err := NewClient(1, proto.WithConfigSource(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if v := c.GetString("u1", "exp", "model", "none"); v != "none" {
+		t.Errorf("got %s without inheritance, want none", v)
+	}
+
+	inheriting, err := NewClient(1, proto.WithConfigSource(NewMemorySource(testConfigList(t, 1, 1, exp))), proto.WithInheritDefault())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inheriting.Close()
+
+	exp["inherit_default"] = true
+	source.Set(testConfigList(t, 2, 1, exp))
+	if err := c.ab.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []*Client{c, inheriting} {
+		if v := c.GetString("u1", "exp", "model", "none"); v != "v1" {
+			t.Errorf("got model %s, want v1", v)
+		}
+		want := map[string]interface{}{"ctr": json.Number("0.3"), "cvr": json.Number("0.5")}
+		if v := c.GetMap("u1", "exp", "ranker", nil); !reflect.DeepEqual(v, want) {
+			t.Errorf("got ranker %v, want %v", v, want)
+		}
+	}
+}
+
+func TestKeyPaths(t *testing.T) {
+	exp := testExperiment("exp", 1)
+	exp["config_map"] = map[string]interface{}{
+		"default": map[string]interface{}{},
+		"treat": map[string]interface{}{
+			"ranker": map[string]interface{}{"weights": map[string]interface{}{"ctr": 0.3}, "version": 2},
+			"slots":  []interface{}{map[string]interface{}{"size": 1}, map[string]interface{}{"size": 5}},
+			"a.b":    "flat",
+		},
+	}
+	c, err := NewClient(1, proto.WithConfigSource(NewMemorySource(testConfigList(t, 1, 1, exp))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if v := c.GetFloat64("u1", "exp", "ranker.weights.ctr", -1); v != 0.3 {
+		t.Errorf("got %v, want 0.3", v)
+	}
+	if v := c.GetInt64("u1", "exp", "slots[1].size", -1); v != 5 {
+		t.Errorf("got %v, want 5", v)
+	}
+	if v := c.GetString("u1", "exp", "a.b", ""); v != "flat" {
+		t.Errorf("got %q, want the flat key", v)
+	}
+	if v := GetFrom(c, "u1", "exp", "ranker.weights", map[string]float64(nil)); v["ctr"] != 0.3 {
+		t.Errorf("got %v", v)
+	}
+	if n := testing.AllocsPerRun(100, func() { c.GetInt64("u1", "exp", "ranker.version", -1) }); n != 0 {
+		t.Errorf("GetInt64 on a path: %v allocs", n)
+	}
+
+	for _, test := range []struct {
+		key, segment string
+	}{
+		{"ranker.bias.ctr", "bias"},
+		{"ranker.weights.cvr", "cvr"},
+		{"slots[2].size", "[2]"},
+		{"ranker[0]", "[0]"},
+	} {
+		var v float64
+		err := c.ab.GetKey("u1", "exp", test.key, &v)
+		var notFound *KeyNotFoundError
+		if !errors.Is(err, ErrKeyNotFound) || !errors.As(err, &notFound) || notFound.Segment != test.segment {
+			t.Errorf("%s: got %v, want segment %s not found", test.key, err, test.segment)
+		}
+	}
+
+	for _, key := range []string{"ranker..ctr", ".ranker", "slots[x]", "slots[1]size", "slots[1"} {
+		var v float64
+		if err := c.ab.GetKey("u1", "exp", key, &v); err == nil || errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("%s: got %v, want a syntax error", key, err)
+		}
+	}
+}
+
+func TestGetConfigConflicts(t *testing.T) {
+	low, high, other := testExperiment("a", 1), testExperiment("b", 2), testExperiment("c", 3)
+	high["priority"] = 10
+	other["config_map"] = map[string]interface{}{
+		"treat": map[string]interface{}{"other": "c"},
+	}
+	list := testConfigList(t, 1, 1, low, high, other)
+
+	c, err := NewClient(1, proto.WithConfigSource(NewMemorySource(list)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for i := 0; i < 10; i++ {
+		config, provenance := c.GetConfigWithProvenance("u1")
+		if v := config["key"]; v != json.Number("2") {
+			t.Fatalf("got key %v, want 2 from the higher priority", v)
+		}
+		want := map[string]string{"key": "b", "other": "c"}
+		if !reflect.DeepEqual(provenance, want) {
+			t.Fatalf("got provenance %v, want %v", provenance, want)
+		}
+	}
+	if n := c.ab.Status().ConfigConflicts; n != 10 {
+		t.Errorf("got %d conflicts, want 10", n)
+	}
+
+	strict, err := NewClient(1, proto.WithConfigSource(NewMemorySource(list)), proto.WithConflictPolicy(proto.ConflictError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strict.Close()
+	config, err := strict.ab.GetConfig("u1")
+	var conflict *ConfigConflictError
+	if !errors.Is(err, ErrConfigConflict) || !errors.As(err, &conflict) ||
+		!reflect.DeepEqual(conflict.Conflicts, map[string][]string{"key": {"b", "a"}}) {
+		t.Errorf("got %v, want key in conflict between b and a", err)
+	}
+	if _, ok := config["key"]; ok || config["other"] != "c" {
+		t.Errorf("got %v, want only other", config)
+	}
+
+	namespaced, err := NewClient(1, proto.WithConfigSource(NewMemorySource(list)), proto.WithConflictPolicy(proto.ConflictNamespace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer namespaced.Close()
+	config, provenance, err := namespaced.ab.GetConfigWithProvenance("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"a.key": json.Number("1"), "b.key": json.Number("2"), "c.other": "c"}
+	if !reflect.DeepEqual(config, want) || provenance["a.key"] != "a" {
+		t.Errorf("got %v from %v, want %v", config, provenance, want)
+	}
+	if v := namespaced.GetInt64("u1", "b", "key", -1); v != 2 {
+		t.Errorf("got %d, want GetInt64 unaffected", v)
+	}
+
+	if _, err := NewClient(1, proto.WithConfigSource(NewMemorySource(list)), proto.WithConflictPolicy("priority")); err == nil {
+		t.Error("got no error for an unknown conflict policy")
+	}
+}
+
+func TestGetParam(t *testing.T) {
+	low, high := testExperiment("rank_q1", 1), testExperiment("rank_q2", 2)
+	high["priority"] = 10
+	high["config_map"] = map[string]interface{}{
+		"treat": map[string]interface{}{"key": 2, "ranker": map[string]interface{}{"ctr": 0.3}, "on": true},
+	}
+	disabled := testExperiment("old", 3)
+	disabled["status"] = -1
+
+	c, err := NewClient(1, proto.WithConfigSource(NewMemorySource(testConfigList(t, 1, 1, low, high, disabled))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if v := c.GetParamInt64("u1", "key", -1); v != 2 {
+		t.Errorf("got %d, want 2 from the higher priority", v)
+	}
+	if v := c.GetParamFloat64("u1", "ranker.ctr", -1); v != 0.3 {
+		t.Errorf("got %v, want 0.3", v)
+	}
+	if v := c.GetParamBool("u1", "on", false); !v {
+		t.Errorf("got %v, want true", v)
+	}
+	if v := c.GetParamString("u1", "missing", "def"); v != "def" {
+		t.Errorf("got %s, want def", v)
+	}
+	if v := c.GetParam("u1", "key", nil); v != json.Number("2") {
+		t.Errorf("got %v, want 2", v)
+	}
+	if v := ParamFrom(c, "u1", "key", 0); v != 2 {
+		t.Errorf("got %d, want 2", v)
+	}
+	var v int
+	if expName, err := c.ab.GetParam("u1", "key", &v); err != nil || expName != "rank_q2" {
+		t.Errorf("got %s, %v, want rank_q2", expName, err)
+	}
+
+	owners, err := c.ab.ParamOwners("key")
+	if err != nil || !reflect.DeepEqual(owners, []string{"rank_q2", "rank_q1"}) {
+		t.Errorf("got owners %v, %v", owners, err)
+	}
+	if contested := c.Status().ContestedParams[1]; !reflect.DeepEqual(contested, []string{"key"}) {
+		t.Errorf("got contested %v, want [key]", contested)
+	}
+
+	strict, err := NewClient(1, proto.WithConfigSource(NewMemorySource(testConfigList(t, 1, 1, low, high))), proto.WithConflictPolicy(proto.ConflictError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strict.Close()
+	if _, err := strict.ab.GetParam("u1", "key", &v); !errors.Is(err, ErrConfigConflict) {
+		t.Errorf("got %v, want a conflict", err)
+	}
+	if v := strict.GetParamInt64("u1", "on", -1); v != -1 {
+		t.Errorf("got %d, want the default for a mismatched type", v)
+	}
+	if v := strict.GetParamBool("u1", "on", false); !v {
+		t.Errorf("got %v, want true from the only owner", v)
+	}
+}
@@ -0,0 +1,203 @@
+package abtest
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	durationType        = reflect.TypeOf(time.Duration(0))
+)
+
+// coerce converts val, a config value decoded with UseNumber, into dst.
+// Numbers convert to any numeric kind they fit in exactly, durations also parse from strings,
+// and slices and maps convert element by element. Types implementing json.Unmarshaler,
+// structs and arrays are decoded from the JSON of val. dst is left untouched on error.
+// path names val in the errors.
+func coerce(path string, val interface{}, dst reflect.Value) error {
+	v := reflect.New(dst.Type()).Elem()
+	if err := coerceValue(path, val, v); err != nil {
+		return err
+	}
+	dst.Set(v)
+	return nil
+}
+
+func coerceValue(path string, val interface{}, dst reflect.Value) error {
+	if val == nil {
+		return nil
+	}
+
+	t := dst.Type()
+	if reflect.PtrTo(t).Implements(jsonUnmarshalerType) {
+		return coerceJSON(path, val, dst)
+	}
+
+	// Durations are also written like "250ms".
+	if s, ok := val.(string); ok && t == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return &TypeMismatchError{Key: path, Expected: t, Actual: reflect.TypeOf(val), Err: err}
+		}
+		dst.SetInt(int64(d))
+		return nil
+	}
+
+	mismatch := func() error {
+		return &TypeMismatchError{Key: path, Expected: t, Actual: reflect.TypeOf(val)}
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		if !reflect.TypeOf(val).Implements(t) {
+			return mismatch()
+		}
+		dst.Set(reflect.ValueOf(val))
+	case reflect.Ptr:
+		elem := reflect.New(t.Elem())
+		if err := coerceValue(path, val, elem.Elem()); err != nil {
+			return err
+		}
+		dst.Set(elem)
+	case reflect.Bool:
+		b, ok := val.(bool)
+		if !ok {
+			return mismatch()
+		}
+		dst.SetBool(b)
+	case reflect.String:
+		s, ok := val.(string)
+		if !ok {
+			return mismatch()
+		}
+		dst.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := toInt64(val)
+		if !ok || dst.OverflowInt(i) {
+			return mismatch()
+		}
+		dst.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, ok := toUint64(val)
+		if !ok || dst.OverflowUint(u) {
+			return mismatch()
+		}
+		dst.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, ok := toFloat64(val)
+		if !ok || dst.OverflowFloat(f) {
+			return mismatch()
+		}
+		dst.SetFloat(f)
+	case reflect.Slice:
+		items, ok := val.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		s := reflect.MakeSlice(t, len(items), len(items))
+		for i, item := range items {
+			if err := coerceValue(path+"["+strconv.Itoa(i)+"]", item, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		dst.Set(s)
+	case reflect.Map:
+		m, ok := val.(map[string]interface{})
+		if !ok || t.Key().Kind() != reflect.String {
+			return mismatch()
+		}
+		mv := reflect.MakeMapWithSize(t, len(m))
+		for k, item := range m {
+			elem := reflect.New(t.Elem()).Elem()
+			if err := coerceValue(path+"."+k, item, elem); err != nil {
+				return err
+			}
+			mv.SetMapIndex(reflect.ValueOf(k).Convert(t.Key()), elem)
+		}
+		dst.Set(mv)
+	case reflect.Struct, reflect.Array:
+		return coerceJSON(path, val, dst)
+	default:
+		return mismatch()
+	}
+
+	return nil
+}
+
+// coerceJSON decodes val into dst through JSON, for the types with their own decoding.
+func coerceJSON(path string, val interface{}, dst reflect.Value) error {
+	bs, err := json.Marshal(val)
+	if err == nil {
+		err = json.Unmarshal(bs, dst.Addr().Interface())
+	}
+	if err != nil {
+		return &TypeMismatchError{Key: path, Expected: dst.Type(), Actual: reflect.TypeOf(val), Err: err}
+	}
+	return nil
+}
+
+func toInt64(val interface{}) (i int64, ok bool) {
+	switch n := val.(type) {
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return i, true
+		}
+		// Integral numbers in exponent form, e.g. 1e3.
+		f, err := n.Float64()
+		if err != nil {
+			return
+		}
+		return floatToInt64(f)
+	case float64:
+		return floatToInt64(n)
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int32:
+		return int64(n), true
+	}
+	return
+}
+
+func floatToInt64(f float64) (i int64, ok bool) {
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return
+	}
+	return int64(f), true
+}
+
+func toUint64(val interface{}) (u uint64, ok bool) {
+	if n, isNumber := val.(json.Number); isNumber {
+		if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+			return u, true
+		}
+	}
+
+	i, ok := toInt64(val)
+	if !ok || i < 0 {
+		return 0, false
+	}
+	return uint64(i), true
+}
+
+func toFloat64(val interface{}) (f float64, ok bool) {
+	switch n := val.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	}
+	return
+}
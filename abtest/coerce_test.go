@@ -0,0 +1,108 @@
+package abtest
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCoerce(t *testing.T) {
+	var config map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(`{
+		"int": 42, "big": 9007199254740993, "neg": -1, "float": 1.5, "exp": 1e3,
+		"str": "s", "bool": true, "ints": [1, 2, 3], "mixed": [1, "a"],
+		"weights": {"ctr": 0.3, "cvr": 0.7}, "obj": {"name": "n", "size": 2}
+	}`))
+	dec.UseNumber()
+	if err := dec.Decode(&config); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		i64     int64
+		i32     int32
+		i8      int8
+		u       uint
+		f32     float32
+		f64     float64
+		d       time.Duration
+		s       string
+		b       bool
+		ints    []int
+		floats  []float64
+		weights map[string]float64
+		any     interface{}
+		ptr     *int
+		obj     struct {
+			Name string `json:"name"`
+			Size int    `json:"size"`
+		}
+	)
+	for _, test := range []struct {
+		key  string
+		dst  interface{}
+		want interface{}
+	}{
+		{"int", &i64, int64(42)},
+		{"big", &i64, int64(9007199254740993)},
+		{"int", &i32, int32(42)},
+		{"int", &u, uint(42)},
+		{"exp", &i64, int64(1000)},
+		{"float", &f32, float32(1.5)},
+		{"int", &f64, float64(42)},
+		{"int", &d, time.Duration(42)},
+		{"str", &s, "s"},
+		{"bool", &b, true},
+		{"ints", &ints, []int{1, 2, 3}},
+		{"ints", &floats, []float64{1, 2, 3}},
+		{"weights", &weights, map[string]float64{"ctr": 0.3, "cvr": 0.7}},
+		{"str", &any, "s"},
+		{"int", &ptr, func() *int { i := 42; return &i }()},
+	} {
+		if err := coerce(test.key, config[test.key], reflect.ValueOf(test.dst).Elem()); err != nil {
+			t.Errorf("%s into %T: %v", test.key, test.dst, err)
+			continue
+		}
+		if got := reflect.ValueOf(test.dst).Elem().Interface(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s into %T: got %v, want %v", test.key, test.dst, got, test.want)
+		}
+	}
+
+	if err := coerce("obj", config["obj"], reflect.ValueOf(&obj).Elem()); err != nil || obj.Name != "n" || obj.Size != 2 {
+		t.Errorf("obj: got %+v, %v", obj, err)
+	}
+
+	i64 = 7
+	for _, test := range []struct {
+		key  string
+		dst  interface{}
+		path string
+	}{
+		{"float", &i64, "float"},
+		{"neg", &u, "neg"},
+		{"int", &s, "int"},
+		{"str", &b, "str"},
+		{"mixed", &ints, "mixed[1]"},
+		{"obj", &weights, "obj.name"},
+	} {
+		err := coerce(test.key, config[test.key], reflect.ValueOf(test.dst).Elem())
+		var mismatch *TypeMismatchError
+		if !errors.Is(err, ErrTypeMismatch) || !errors.As(err, &mismatch) {
+			t.Errorf("%s into %T: got %v, want ErrTypeMismatch", test.key, test.dst, err)
+			continue
+		}
+		if len(test.path) > 0 && mismatch.Key != test.path {
+			t.Errorf("%s into %T: got key %s, want %s", test.key, test.dst, mismatch.Key, test.path)
+		}
+	}
+	i8 = 0
+	if err := coerce("big", config["big"], reflect.ValueOf(&i8).Elem()); err == nil {
+		t.Error("int8 overflow: no error")
+	}
+	if i64 != 7 {
+		t.Errorf("mismatch changed the result to %d", i64)
+	}
+}
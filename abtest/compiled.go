@@ -0,0 +1,342 @@
+package abtest
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/phoenix-rec/abtest-sdk-go/abtest/consts"
+	logger "github.com/phoenix-rec/abtest-sdk-go/abtest/log"
+	abtest "github.com/phoenix-rec/abtest-sdk-go/abtest/proto"
+)
+
+// view is the compiled, immutable form of the configs of a version.
+// It is built once per update and swapped in atomically, so readers never lock,
+// and it must not be modified once stored.
+type view struct {
+	infoMap  map[int64]map[string]*abtest.ExperimentInfo
+	projects map[int64]*projectView
+}
+
+type projectView struct {
+	experiments map[string]*experimentView
+	layers      map[string]*abtest.Layer
+	ordered     []*experimentView // by Priority descending, then by name
+
+	params    map[string][]*experimentView // key => the enabled experiments setting it, in the order of ordered
+	contested []string                     // the keys set by more than one enabled experiment, sorted
+}
+
+type experimentView struct {
+	name    string
+	info    *abtest.ExperimentInfo
+	configs map[string]*configView // strategy_name => config
+}
+
+// configView is the read-only config of a strategy, with its scalar values decoded ahead of time.
+type configView struct {
+	values map[string]interface{}
+	typed  map[string]typedValue
+}
+
+// typedValue holds the decodings of a config value into the scalar types it converts to losslessly.
+type typedValue struct {
+	kinds typedKind
+	i     int64
+	f     float64
+	b     bool
+	s     string
+}
+
+type typedKind uint8
+
+const (
+	kindInt64 typedKind = 1 << iota
+	kindFloat64
+	kindBool
+	kindString
+)
+
+// newView compiles projectInfoMap, overlaying every strategy on the default config if inheritDefault is set.
+func newView(projectInfoMap map[int64]map[string]*abtest.ExperimentInfo, inheritDefault bool) *view {
+	v := &view{
+		infoMap:  projectInfoMap,
+		projects: make(map[int64]*projectView, len(projectInfoMap)),
+	}
+
+	for projectId, infoMap := range projectInfoMap {
+		layers, errs := abtest.BuildLayers(infoMap)
+		for _, err := range errs {
+			logger.WarnF("project %d: %v", projectId, err)
+		}
+
+		p := &projectView{
+			experiments: make(map[string]*experimentView, len(infoMap)),
+			layers:      layers,
+		}
+		p.ordered = make([]*experimentView, 0, len(infoMap))
+		for expName, info := range infoMap {
+			e := newExperimentView(expName, info, inheritDefault || info.InheritDefault)
+			p.experiments[expName] = e
+			p.ordered = append(p.ordered, e)
+		}
+		sort.Slice(p.ordered, func(i, j int) bool {
+			a, b := p.ordered[i], p.ordered[j]
+			if a.info.Priority != b.info.Priority {
+				return a.info.Priority > b.info.Priority
+			}
+			return a.name < b.name
+		})
+
+		p.indexParams()
+		if len(p.contested) > 0 {
+			logger.WarnF("project %d: keys set by more than one experiment: %s", projectId, strings.Join(p.contested, ", "))
+		}
+		v.projects[projectId] = p
+	}
+
+	return v
+}
+
+// indexParams maps every key to the enabled experiments setting it in any strategy.
+func (p *projectView) indexParams() {
+	p.params = make(map[string][]*experimentView)
+	for _, e := range p.ordered {
+		if e.info.Status == abtest.Disabled {
+			continue
+		}
+
+		keys := make(map[string]bool)
+		for _, config := range e.configs {
+			for k := range config.values {
+				keys[k] = true
+			}
+		}
+		for k := range keys {
+			p.params[k] = append(p.params[k], e)
+			if len(p.params[k]) == 2 {
+				p.contested = append(p.contested, k)
+			}
+		}
+	}
+	sort.Strings(p.contested)
+}
+
+func newExperimentView(name string, info *abtest.ExperimentInfo, inherit bool) *experimentView {
+	e := &experimentView{
+		name:    name,
+		info:    info,
+		configs: make(map[string]*configView, len(info.ConfigMap)),
+	}
+
+	strategyNames := make(map[string]bool, len(info.ConfigMap)+len(info.PartitionsMap))
+	for strategyName := range info.ConfigMap {
+		strategyNames[strategyName] = true
+	}
+	if inherit {
+		// A strategy without a config of its own serves the default config.
+		for strategyName := range info.PartitionsMap {
+			strategyNames[strategyName] = true
+		}
+		for _, strategyName := range info.WhiteMap {
+			strategyNames[strategyName] = true
+		}
+	}
+
+	for strategyName := range strategyNames {
+		config := info.ConfigMap[strategyName]
+		if inherit && strategyName != consts.DefaultStrategyName {
+			config = abtest.MergePatch(info.ConfigMap[consts.DefaultStrategyName], config)
+		}
+
+		cv := &configView{
+			values: config,
+			typed:  make(map[string]typedValue, len(config)),
+		}
+		for k, val := range config {
+			if t, ok := decodeTyped(val); ok {
+				cv.typed[k] = t
+			}
+		}
+		e.configs[strategyName] = cv
+	}
+
+	return e
+}
+
+func decodeTyped(val interface{}) (t typedValue, ok bool) {
+	switch x := val.(type) {
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			t.kinds |= kindInt64
+			t.i = i
+		}
+		if f, err := x.Float64(); err == nil {
+			t.kinds |= kindFloat64
+			t.f = f
+		}
+	case bool:
+		t.kinds = kindBool
+		t.b = x
+	case string:
+		t.kinds = kindString
+		t.s = x
+	}
+
+	return t, t.kinds != 0
+}
+
+// loadView returns the configs being served, nil before the client is opened.
+func (c *ABClient) loadView() *view {
+	v, _ := c.view.Load().(*view)
+	return v
+}
+
+// project returns the view of the project of the client.
+func (c *ABClient) project() (p *projectView, err error) {
+	v := c.loadView()
+	if v == nil {
+		err = ErrClientUninitialized
+		return
+	}
+
+	p, ok := v.projects[c.projectId]
+	if !ok {
+		err = ErrProjectNotFound
+	}
+	return
+}
+
+// lookup resolves the strategy of id in the experiment and returns its config.
+// An expired experiment serves the default config along with ErrExperimentExpired.
+func (c *ABClient) lookup(ctx context.Context, id, expName string) (config *configView, err error) {
+	if !c.isRunning() {
+		err = ErrClientStopped
+		return
+	}
+
+	p, err := c.project()
+	if err != nil {
+		return
+	}
+
+	e, ok := p.experiments[expName]
+	if !ok {
+		err = ErrExperimentNotFound
+		return
+	}
+
+	if e.info.Status == abtest.Disabled {
+		err = ErrExperimentDisabled
+		return
+	}
+
+	detail, err := c.resolve(ctx, p, e.info, id)
+	if err != nil && err != ErrExperimentExpired {
+		return
+	}
+
+	return e.config(detail.Strategy), err
+}
+
+var emptyConfigView = &configView{}
+
+// config returns the config of strategyName, empty if it has none.
+func (e *experimentView) config(strategyName string) *configView {
+	if config, ok := e.configs[strategyName]; ok {
+		return config
+	}
+	return emptyConfigView
+}
+
+// copyValues returns a copy of the config the caller may modify.
+func (cv *configView) copyValues() map[string]interface{} {
+	result := make(map[string]interface{}, len(cv.values))
+	for k, v := range cv.values {
+		result[k] = v
+	}
+	return result
+}
+
+// The scalar getters below are GetKey into their type. A value decoded ahead of time
+// is returned without allocating, anything else falls back to decodeKey.
+
+func (c *ABClient) getBool(ctx context.Context, id, expName, keyName string) (val bool, err error) {
+	config, err := c.lookup(ctx, id, expName)
+	if err != nil && err != ErrExperimentExpired {
+		return
+	}
+
+	if t, ok := config.getTyped(keyName); ok && t.kinds&kindBool != 0 {
+		return t.b, err
+	}
+	return config.decodeBool(keyName, err)
+}
+
+func (c *ABClient) getString(ctx context.Context, id, expName, keyName string) (val string, err error) {
+	config, err := c.lookup(ctx, id, expName)
+	if err != nil && err != ErrExperimentExpired {
+		return
+	}
+
+	if t, ok := config.getTyped(keyName); ok && t.kinds&kindString != 0 {
+		return t.s, err
+	}
+	return config.decodeString(keyName, err)
+}
+
+func (c *ABClient) getInt64(ctx context.Context, id, expName, keyName string) (val int64, err error) {
+	config, err := c.lookup(ctx, id, expName)
+	if err != nil && err != ErrExperimentExpired {
+		return
+	}
+
+	if t, ok := config.getTyped(keyName); ok && t.kinds&kindInt64 != 0 {
+		return t.i, err
+	}
+	return config.decodeInt64(keyName, err)
+}
+
+func (c *ABClient) getFloat64(ctx context.Context, id, expName, keyName string) (val float64, err error) {
+	config, err := c.lookup(ctx, id, expName)
+	if err != nil && err != ErrExperimentExpired {
+		return
+	}
+
+	if t, ok := config.getTyped(keyName); ok && t.kinds&kindFloat64 != 0 {
+		return t.f, err
+	}
+	return config.decodeFloat64(keyName, err)
+}
+
+// The decoders below keep the err of the lookup unless decoding fails.
+// Being separate functions, their results only escape to the heap on the slow path.
+
+func (cv *configView) decodeBool(keyName string, lookupErr error) (val bool, err error) {
+	if err = cv.decodeKey(keyName, &val); err == nil {
+		err = lookupErr
+	}
+	return
+}
+
+func (cv *configView) decodeString(keyName string, lookupErr error) (val string, err error) {
+	if err = cv.decodeKey(keyName, &val); err == nil {
+		err = lookupErr
+	}
+	return
+}
+
+func (cv *configView) decodeInt64(keyName string, lookupErr error) (val int64, err error) {
+	if err = cv.decodeKey(keyName, &val); err == nil {
+		err = lookupErr
+	}
+	return
+}
+
+func (cv *configView) decodeFloat64(keyName string, lookupErr error) (val float64, err error) {
+	if err = cv.decodeKey(keyName, &val); err == nil {
+		err = lookupErr
+	}
+	return
+}
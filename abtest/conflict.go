@@ -0,0 +1,86 @@
+package abtest
+
+import (
+	"context"
+	"sync/atomic"
+
+	logger "github.com/phoenix-rec/abtest-sdk-go/abtest/log"
+	abtest "github.com/phoenix-rec/abtest-sdk-go/abtest/proto"
+)
+
+// GetConfigWithProvenance is like GetConfig, also returning provenance, key => the experiment the value is from.
+func (c *ABClient) GetConfigWithProvenance(id string) (result map[string]interface{}, provenance map[string]string, err error) {
+	return c.GetConfigWithProvenanceContext(context.Background(), id)
+}
+
+// GetConfigWithProvenanceContext is like GetConfigWithProvenance, evaluating for the request in ctx, see ABClient.
+func (c *ABClient) GetConfigWithProvenanceContext(ctx context.Context, id string) (result map[string]interface{}, provenance map[string]string, err error) {
+	result = make(map[string]interface{})
+	provenance = make(map[string]string)
+	if !c.isRunning() {
+		err = ErrClientStopped
+		return
+	}
+
+	p, err := c.project()
+	if err != nil {
+		return
+	}
+
+	policy := c.options.ConflictPolicy
+	var conflicts map[string][]string // key => the experiments setting it, under abtest.ConflictError
+
+	// Experiments are merged by priority, so under abtest.ConflictPriority the first to set a key keeps it.
+	for _, e := range p.ordered {
+		if e.info.Status == abtest.Disabled {
+			continue
+		}
+
+		detail, expErr := c.resolve(ctx, p, e.info, id)
+		if expErr != nil && expErr != ErrExperimentExpired {
+			err = expErr
+			continue
+		}
+
+		for k, v := range e.config(detail.Strategy).values {
+			if policy == abtest.ConflictNamespace {
+				k = e.name + "." + k
+			} else if owner, ok := provenance[k]; ok {
+				c.trackConflict(id, k, owner, e.name)
+				if policy == abtest.ConflictError {
+					if conflicts == nil {
+						conflicts = make(map[string][]string)
+					}
+					if len(conflicts[k]) == 0 {
+						conflicts[k] = []string{owner}
+					}
+					conflicts[k] = append(conflicts[k], e.name)
+				}
+				continue
+			}
+
+			result[k] = v
+			provenance[k] = e.name
+		}
+	}
+
+	if len(conflicts) > 0 {
+		for k := range conflicts {
+			delete(result, k)
+			delete(provenance, k)
+		}
+		if err == nil {
+			err = &ConfigConflictError{Conflicts: conflicts}
+		}
+	}
+
+	return
+}
+
+// trackConflict counts a key set by more than one experiment for id, logging at every power of two.
+func (c *ABClient) trackConflict(id, keyName, owner, expName string) {
+	conflicts := atomic.AddUint64(&c.conflicts, 1)
+	if conflicts&(conflicts-1) == 0 { // is power of two
+		logger.WarnF("GetConfig conflict: %s of %s is also set by %s, id: %s, conflicts: %d", keyName, owner, expName, id, conflicts)
+	}
+}
@@ -3,9 +3,16 @@ package consts
 const (
 	DefaultStrategyName     = "default"
 	DefaultIntervalInSecond = 10
+
+	DefaultReadyRetryIntervalInSecond = 1
+
+	DefaultStreamIdleTimeoutInSecond = 60
+
+	DefaultLayerSlotCount = 100
 )
 
 const (
 	DefaultAbConfigHost = "http://phoenix-api.icocofun.com"
 	DefaultAbApiPath    = "/abtest/httpapi/get_all_config_list"
+	DefaultAbStreamPath = "/abtest/httpapi/config_stream"
 )
@@ -1,16 +1,108 @@
 package abtest
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	abtest "github.com/phoenix-rec/abtest-sdk-go/abtest/proto"
+)
 
 var (
 	ErrClientStopped       = errors.New("client stopped")
 	ErrClientUninitialized = errors.New("client uninitialized")
 	ErrClientSettingErr    = errors.New("client project_id is empty")
 	ErrExperimentDisabled  = errors.New("experiment disabled")
+	ErrExperimentExpired   = abtest.ErrExperimentExpired
+	ErrExperimentInvalid   = abtest.ErrExperimentInvalid
 	ErrExperimentNotFound  = errors.New("experiment not found")
 	ErrProjectNotFound     = errors.New("project not found")
 	ErrExperimentNotMatch  = errors.New("experiment not match")
 	ErrKeyNotFound         = errors.New("key not found")
+	ErrLayerNotFound       = errors.New("layer not found")
 	ErrSnapshotDisabled    = errors.New("snapshot disabled")
+	ErrTypeMismatch        = errors.New("type mismatch")
+	ErrConfigConflict      = errors.New("config conflict")
 	ErrAllDefault          = errors.New("A/B Server is unavailable. All of the experiments are using the default value in code!")
 )
+
+// ReadyTimeoutError is returned when the configs are not loaded before the deadline.
+type ReadyTimeoutError struct {
+	Err     error // the error of the context, context.DeadlineExceeded or context.Canceled
+	LastErr error // the last error of the config source, nil if no attempt has completed
+}
+
+func (e *ReadyTimeoutError) Error() string {
+	return fmt.Sprintf("configs not ready: %v, last err: %v", e.Err, e.LastErr)
+}
+
+func (e *ReadyTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// TypeMismatchError is returned when a config value does not convert to the type asked for.
+type TypeMismatchError struct {
+	Key      string       // the key, or the path to the element, of the value
+	Expected reflect.Type // the type asked for
+	Actual   reflect.Type // the type of the config value
+	Err      error        // the decoding error, if decoded from JSON
+}
+
+func (e *TypeMismatchError) Error() string {
+	msg := fmt.Sprintf("type mismatch: %s is %v, not %v", e.Key, e.Actual, e.Expected)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Is makes errors.Is(err, ErrTypeMismatch) hold for every TypeMismatchError.
+func (e *TypeMismatchError) Is(target error) bool {
+	return target == ErrTypeMismatch
+}
+
+func (e *TypeMismatchError) Unwrap() error {
+	return e.Err
+}
+
+// KeyNotFoundError is returned when a key path, e.g. ranker.weights.ctr, does not resolve in a config.
+type KeyNotFoundError struct {
+	Key     string // the key path
+	Segment string // the first segment not found, e.g. weights or [2]
+}
+
+func (e *KeyNotFoundError) Error() string {
+	return fmt.Sprintf("key not found: %s has no %s", e.Key, e.Segment)
+}
+
+// Is makes errors.Is(err, ErrKeyNotFound) hold for every KeyNotFoundError.
+func (e *KeyNotFoundError) Is(target error) bool {
+	return target == ErrKeyNotFound
+}
+
+// ConfigConflictError is returned by GetConfig under abtest.ConflictError
+// when experiments set the same keys, which are left out of the config.
+type ConfigConflictError struct {
+	Conflicts map[string][]string // key => the experiments setting it, by priority
+}
+
+func (e *ConfigConflictError) Error() string {
+	keys := make([]string, 0, len(e.Conflicts))
+	for k := range e.Conflicts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	msg := "config conflict:"
+	for _, k := range keys {
+		msg += fmt.Sprintf(" %s set by %s;", k, strings.Join(e.Conflicts[k], ", "))
+	}
+	return strings.TrimSuffix(msg, ";")
+}
+
+// Is makes errors.Is(err, ErrConfigConflict) hold for every ConfigConflictError.
+func (e *ConfigConflictError) Is(target error) bool {
+	return target == ErrConfigConflict
+}
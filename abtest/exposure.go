@@ -0,0 +1,46 @@
+package abtest
+
+import (
+	"context"
+	"time"
+
+	"github.com/phoenix-rec/abtest-sdk-go/abtest/exposure"
+	abtest "github.com/phoenix-rec/abtest-sdk-go/abtest/proto"
+)
+
+func (c *ABClient) logExposure(ctx context.Context, info *abtest.ExperimentInfo, id string, detail abtest.EvaluationDetail) {
+	if c.options.ExposureLogger == nil || !c.exposed(detail.Reason) {
+		return
+	}
+
+	r := exposure.Record{
+		ProjectId: c.projectId,
+		ExpName:   info.Name,
+		ExpID:     detail.ExpID,
+		Strategy:  detail.Strategy,
+		Ut:        detail.Ut,
+		Id:        id,
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		Reason:    detail.Reason,
+		Partition: detail.Partition,
+	}
+	if c.options.ExposureFilter != nil && !c.options.ExposureFilter.Allow(ctx, &r) {
+		return
+	}
+
+	c.options.ExposureLogger.Log(r)
+}
+
+// exposed reports whether evaluations of reason are logged as exposures.
+func (c *ABClient) exposed(reason abtest.EvaluationReason) bool {
+	if c.options.ExposureReasons == nil {
+		return abtest.IsAssigned(reason)
+	}
+
+	for _, r := range c.options.ExposureReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+package exposure
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logger "github.com/phoenix-rec/abtest-sdk-go/abtest/log"
+)
+
+const (
+	DefaultQueueSize     = 8192
+	DefaultBatchSize     = 256
+	DefaultFlushInterval = time.Second
+)
+
+// Record is an exposure: id was served strategy of the experiment.
+type Record struct {
+	ProjectId int64  `json:"project_id"`
+	ExpName   string `json:"exp_name"`
+	ExpID     string `json:"exp_id"`
+	Strategy  string `json:"strategy"`
+	Ut        int64  `json:"ut"` // version of the experiment config
+	Id        string `json:"id"`
+	Timestamp int64  `json:"timestamp"` // unix milli
+	Reason    string `json:"reason"`
+	Partition int64  `json:"partition"` // partition the id hashes into, -1 if not hashed
+}
+
+// Logger receives the exposures of the A/B client.
+// Log is called on the evaluation path, so it must not block.
+type Logger interface {
+	Log(r Record)
+	Close() error
+}
+
+// Sink writes batches of exposures to their destination.
+// Write is only called from a single goroutine.
+type Sink interface {
+	Write(records []Record) error
+	Close() error
+}
+
+type AsyncOption func(*AsyncLogger)
+
+// WithQueueSize bounds the number of exposures waiting to be written.
+// Exposures logged while the queue is full are dropped.
+func WithQueueSize(n int) AsyncOption {
+	return func(l *AsyncLogger) {
+		l.queueSize = n
+	}
+}
+
+// WithBatchSize sets the maximum number of exposures per Sink.Write.
+func WithBatchSize(n int) AsyncOption {
+	return func(l *AsyncLogger) {
+		l.batchSize = n
+	}
+}
+
+// WithFlushInterval sets how long an incomplete batch may wait before it is written.
+func WithFlushInterval(d time.Duration) AsyncOption {
+	return func(l *AsyncLogger) {
+		l.flushInterval = d
+	}
+}
+
+// AsyncLogger batches exposures in memory and writes them to a Sink on a background goroutine.
+// Memory is bounded by the queue size: when the sink falls behind, exposures are dropped
+// rather than blocking the caller.
+type AsyncLogger struct {
+	sink          Sink
+	queueSize     int
+	batchSize     int
+	flushInterval time.Duration
+
+	queue     chan Record
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+
+	dropped  uint64
+	errCount uint64
+}
+
+var _ Logger = (*AsyncLogger)(nil)
+
+func NewAsyncLogger(sink Sink, opts ...AsyncOption) *AsyncLogger {
+	l := &AsyncLogger{
+		sink:          sink,
+		queueSize:     DefaultQueueSize,
+		batchSize:     DefaultBatchSize,
+		flushInterval: DefaultFlushInterval,
+		done:          make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(l)
+	}
+	l.queue = make(chan Record, l.queueSize)
+
+	logger.InitDefaultLogger()
+	go l.run()
+
+	return l
+}
+
+func (l *AsyncLogger) Log(r Record) {
+	defer func() {
+		// Logging after Close.
+		recover()
+	}()
+
+	select {
+	case l.queue <- r:
+	default:
+		dropped := atomic.AddUint64(&l.dropped, 1)
+		if dropped&(dropped-1) == 0 { // is power of two
+			logger.WarnF("exposure queue is full, %d exposure(s) dropped", dropped)
+		}
+	}
+}
+
+// Dropped returns the number of exposures dropped because the queue was full.
+func (l *AsyncLogger) Dropped() uint64 {
+	return atomic.LoadUint64(&l.dropped)
+}
+
+// Close writes the queued exposures and closes the sink.
+func (l *AsyncLogger) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.queue)
+		<-l.done
+		l.closeErr = l.sink.Close()
+	})
+
+	return l.closeErr
+}
+
+func (l *AsyncLogger) run() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Record, 0, l.batchSize)
+	for {
+		select {
+		case r, ok := <-l.queue:
+			if !ok {
+				l.flush(batch)
+				return
+			}
+
+			batch = append(batch, r)
+			if len(batch) >= l.batchSize {
+				l.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			l.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (l *AsyncLogger) flush(batch []Record) {
+	if len(batch) == 0 {
+		return
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			logger.ErrorF("exposure sink err: %v", err)
+		}
+	}()
+
+	if err := l.sink.Write(batch); err != nil {
+		errCount := atomic.AddUint64(&l.errCount, 1)
+		if errCount&(errCount-1) == 0 { // is power of two
+			logger.WarnF("exposure sink err: %v, %d batch(es) lost", err, errCount)
+		}
+	}
+}
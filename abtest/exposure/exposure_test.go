@@ -0,0 +1,177 @@
+package exposure
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memorySink struct {
+	m       sync.Mutex
+	batches [][]Record
+	block   chan struct{}
+}
+
+func (s *memorySink) Write(records []Record) error {
+	if s.block != nil {
+		<-s.block
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.batches = append(s.batches, append([]Record(nil), records...))
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
+
+func TestAsyncLoggerBatches(t *testing.T) {
+	sink := new(memorySink)
+	l := NewAsyncLogger(sink, WithBatchSize(2), WithFlushInterval(time.Hour))
+	for i := 0; i < 5; i++ {
+		l.Log(Record{ExpName: "exp", Id: "u"})
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink.batches) != 3 || len(sink.batches[0]) != 2 || len(sink.batches[2]) != 1 {
+		t.Errorf("unexpected batches %v", sink.batches)
+	}
+}
+
+func TestAsyncLoggerDrops(t *testing.T) {
+	sink := &memorySink{block: make(chan struct{})}
+	l := NewAsyncLogger(sink, WithQueueSize(1), WithBatchSize(1))
+	for i := 0; i < 10; i++ {
+		l.Log(Record{Id: "u"})
+	}
+	close(sink.block)
+	l.Close()
+
+	if l.Dropped() == 0 {
+		t.Error("nothing dropped")
+	}
+	var written uint64
+	for _, batch := range sink.batches {
+		written += uint64(len(batch))
+	}
+	if written+l.Dropped() != 10 {
+		t.Errorf("written %d, dropped %d, want 10 in total", written, l.Dropped())
+	}
+}
+
+func TestFileSinkRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exposure.jsonl")
+	sink, err := NewFileSink(path, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := sink.Write([]Record{{ExpName: "exp", Id: "u"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.Close()
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := 0
+		for scanner := bufio.NewScanner(f); scanner.Scan(); {
+			lines++
+		}
+		f.Close()
+		if lines == 0 {
+			t.Errorf("%s is empty", name)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("too many backups: %v", err)
+	}
+}
+
+func TestFilterDedup(t *testing.T) {
+	f := NewFilter(WithDedup(2, time.Hour))
+	ctx := context.Background()
+	r1 := Record{Id: "u1", ExpName: "exp", Strategy: "a", Ut: 1}
+	r2 := Record{Id: "u2", ExpName: "exp", Strategy: "a", Ut: 1}
+	r3 := Record{Id: "u3", ExpName: "exp", Strategy: "a", Ut: 1}
+
+	if !f.Allow(ctx, &r1) || f.Allow(ctx, &r1) {
+		t.Error("r1 not de-duplicated")
+	}
+
+	// A new config version is a new exposure.
+	r1.Ut = 2
+	if !f.Allow(ctx, &r1) {
+		t.Error("r1 of a new version dropped")
+	}
+
+	// r1 is evicted as the least recently seen.
+	f.Allow(ctx, &r2)
+	f.Allow(ctx, &r3)
+	if !f.Allow(ctx, &r1) {
+		t.Error("r1 not evicted")
+	}
+
+	f = NewFilter(WithDedup(2, 0))
+	if !f.Allow(ctx, &r1) || !f.Allow(ctx, &r1) {
+		t.Error("r1 de-duplicated after ttl")
+	}
+
+	// Without a size, nothing is evicted.
+	f = NewFilter(WithDedup(0, time.Hour))
+	f.Allow(ctx, &r1)
+	f.Allow(ctx, &r2)
+	f.Allow(ctx, &r3)
+	if f.Allow(ctx, &r1) || f.Allow(ctx, &r3) {
+		t.Error("unbounded dedup evicted")
+	}
+}
+
+func TestFilterSampling(t *testing.T) {
+	f := NewFilter(WithDefaultSampleRate(0), WithSampleRate("half", 0.5))
+	ctx := context.Background()
+	if f.Allow(ctx, &Record{Id: "u1", ExpName: "exp"}) {
+		t.Error("unsampled experiment logged")
+	}
+
+	allowed := 0
+	for i := 0; i < 1000; i++ {
+		r := Record{Id: fmt.Sprint(i), ExpName: "half"}
+		allow := f.Allow(ctx, &r)
+		if allow != f.Allow(ctx, &r) {
+			t.Fatal("sampling is not stable by id")
+		}
+		if allow {
+			allowed++
+		}
+	}
+	if allowed < 400 || allowed > 600 {
+		t.Errorf("%d of 1000 sampled at rate 0.5", allowed)
+	}
+}
+
+func TestFilterRequestScope(t *testing.T) {
+	f := NewFilter()
+	r := Record{Id: "u1", ExpName: "exp", Strategy: "a"}
+
+	ctx := NewContext(context.Background())
+	if !f.Allow(ctx, &r) || f.Allow(ctx, &r) {
+		t.Error("not logged once per request")
+	}
+	if !f.Allow(NewContext(context.Background()), &r) {
+		t.Error("dropped in a new request")
+	}
+}
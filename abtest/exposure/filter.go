@@ -0,0 +1,186 @@
+package exposure
+
+import (
+	"container/list"
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// Filter decides which exposures are worth logging, so hot paths reading the same
+// experiment over and over do not flood the pipeline.
+// It is safe for concurrent use.
+type Filter struct {
+	dedup *dedup
+
+	defaultRate float64
+	rates       map[string]float64 // exp_name => sample rate
+}
+
+type FilterOption func(*Filter)
+
+// WithDedup drops an exposure if the same (id, exp_name, strategy, ut) was logged within ttl.
+// At most size keys are remembered, the least recently seen are evicted first.
+// A size <= 0 never evicts, so memory grows with the distinct exposures.
+func WithDedup(size int, ttl time.Duration) FilterOption {
+	return func(f *Filter) {
+		f.dedup = newDedup(size, ttl)
+	}
+}
+
+// WithSampleRate logs the exposures of expName for a rate fraction of the ids.
+// Sampling is by id, so an id is either always or never logged.
+func WithSampleRate(expName string, rate float64) FilterOption {
+	return func(f *Filter) {
+		f.rates[expName] = rate
+	}
+}
+
+// WithDefaultSampleRate is the sample rate of the experiments without their own.
+func WithDefaultSampleRate(rate float64) FilterOption {
+	return func(f *Filter) {
+		f.defaultRate = rate
+	}
+}
+
+func NewFilter(opts ...FilterOption) *Filter {
+	f := &Filter{
+		defaultRate: 1,
+		rates:       make(map[string]float64),
+	}
+	for _, o := range opts {
+		o(f)
+	}
+
+	return f
+}
+
+// Allow reports whether r should be logged. ctx is checked for a request scope
+// created by NewContext, which lets only the first exposure per request through.
+func (f *Filter) Allow(ctx context.Context, r *Record) bool {
+	if !f.sampled(r) {
+		return false
+	}
+
+	if s, ok := ctx.Value(scopeKey{}).(*scope); ok && s.seen(r) {
+		return false
+	}
+
+	if f.dedup != nil && f.dedup.seen(r, time.Now()) {
+		return false
+	}
+
+	return true
+}
+
+func (f *Filter) sampled(r *Record) bool {
+	rate, ok := f.rates[r.ExpName]
+	if !ok {
+		rate = f.defaultRate
+	}
+
+	switch {
+	case rate >= 1:
+		return true
+	case rate <= 0:
+		return false
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(r.ExpName))
+	h.Write([]byte{0})
+	h.Write([]byte(r.Id))
+	return float64(h.Sum64()%10000) < rate*10000
+}
+
+type dedupKey struct {
+	id       string
+	expName  string
+	strategy string
+	ut       int64
+}
+
+type dedupEntry struct {
+	key  dedupKey
+	seen time.Time
+}
+
+// dedup is an LRU of the recently logged exposures.
+type dedup struct {
+	m     sync.Mutex
+	size  int
+	ttl   time.Duration
+	order *list.List // front is the most recently seen
+	index map[dedupKey]*list.Element
+}
+
+func newDedup(size int, ttl time.Duration) *dedup {
+	return &dedup{
+		size:  size,
+		ttl:   ttl,
+		order: list.New(),
+		index: make(map[dedupKey]*list.Element),
+	}
+}
+
+func (d *dedup) seen(r *Record, now time.Time) bool {
+	key := dedupKey{id: r.Id, expName: r.ExpName, strategy: r.Strategy, ut: r.Ut}
+
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if e, ok := d.index[key]; ok {
+		entry := e.Value.(*dedupEntry)
+		if now.Sub(entry.seen) < d.ttl {
+			d.order.MoveToFront(e)
+			return true
+		}
+
+		entry.seen = now
+		d.order.MoveToFront(e)
+		return false
+	}
+
+	d.index[key] = d.order.PushFront(&dedupEntry{key: key, seen: now})
+	for d.size > 0 && d.order.Len() > d.size {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.index, oldest.Value.(*dedupEntry).key)
+	}
+
+	return false
+}
+
+type scopeKey struct{}
+
+type scopeEntry struct {
+	id       string
+	expName  string
+	strategy string
+}
+
+// scope remembers the exposures already logged in a request.
+type scope struct {
+	m      sync.Mutex
+	logged map[scopeEntry]bool
+}
+
+// NewContext returns a context scoping exposures to a single request:
+// a Filter logs only the first exposure per id, experiment and strategy within it.
+func NewContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, scopeKey{}, &scope{logged: make(map[scopeEntry]bool)})
+}
+
+func (s *scope) seen(r *Record) bool {
+	entry := scopeEntry{id: r.Id, expName: r.ExpName, strategy: r.Strategy}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.logged[entry] {
+		return true
+	}
+	s.logged[entry] = true
+	return false
+}
@@ -0,0 +1,185 @@
+package exposure
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+var (
+	_ Sink = (*WriterSink)(nil)
+	_ Sink = (*FileSink)(nil)
+	_ Sink = (*HTTPSink)(nil)
+)
+
+// WriterSink writes exposures to w as JSON lines.
+type WriterSink struct {
+	w io.Writer
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// NewStdoutSink writes exposures to stdout as JSON lines.
+func NewStdoutSink() *WriterSink {
+	return NewWriterSink(os.Stdout)
+}
+
+func (s *WriterSink) Write(records []Record) (err error) {
+	bw := bufio.NewWriter(s.w)
+	enc := json.NewEncoder(bw)
+	for _, r := range records {
+		if err = enc.Encode(r); err != nil {
+			return
+		}
+	}
+
+	return bw.Flush()
+}
+
+func (s *WriterSink) Close() error {
+	return nil
+}
+
+// FileSink writes exposures to a JSON lines file, rotating it once it grows beyond maxSize bytes.
+// Rotated files are named path.1 (the newest) up to path.<maxBackups>.
+type FileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	f    *os.File
+	size int64
+}
+
+func NewFileSink(path string, maxSize int64, maxBackups int) (s *FileSink, err error) {
+	s = &FileSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err = s.open(); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+func (s *FileSink) Write(records []Record) (err error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err = enc.Encode(r); err != nil {
+			return
+		}
+	}
+
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(buf.Len()) > s.maxSize {
+		if err = s.rotate(); err != nil {
+			return
+		}
+	}
+
+	n, err := s.f.Write(buf.Bytes())
+	s.size += int64(n)
+	return
+}
+
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
+
+func (s *FileSink) open() (err error) {
+	s.f, err = os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+
+	info, err := s.f.Stat()
+	if err != nil {
+		s.f.Close()
+		return
+	}
+	s.size = info.Size()
+
+	return
+}
+
+func (s *FileSink) rotate() (err error) {
+	if err = s.f.Close(); err != nil {
+		return
+	}
+
+	if s.maxBackups <= 0 {
+		os.Remove(s.path)
+		return s.open()
+	}
+
+	os.Remove(s.backup(s.maxBackups))
+	for i := s.maxBackups - 1; i >= 1; i-- {
+		os.Rename(s.backup(i), s.backup(i+1))
+	}
+	if err = os.Rename(s.path, s.backup(1)); err != nil {
+		return
+	}
+
+	return s.open()
+}
+
+func (s *FileSink) backup(i int) string {
+	return fmt.Sprintf("%s.%d", s.path, i)
+}
+
+// HTTPSink posts every batch of exposures to url as a JSON array.
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPSink(url string) *HTTPSink {
+	return NewHTTPSinkWithClient(url, &http.Client{Timeout: 5 * time.Second})
+}
+
+func NewHTTPSinkWithClient(url string, client *http.Client) *HTTPSink {
+	return &HTTPSink{
+		url:    url,
+		client: client,
+	}
+}
+
+func (s *HTTPSink) Write(records []Record) (err error) {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return
+	}
+
+	request, err := http.NewRequest("POST", s.url, bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := s.client.Do(request)
+	if err != nil {
+		return
+	}
+	defer httpResp.Body.Close()
+	io.Copy(ioutil.Discard, httpResp.Body)
+
+	if httpResp.StatusCode < 200 || httpResp.StatusCode >= 300 {
+		err = fmt.Errorf("unexpected status: %s", httpResp.Status)
+	}
+
+	return
+}
+
+func (s *HTTPSink) Close() error {
+	return nil
+}
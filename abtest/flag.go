@@ -0,0 +1,166 @@
+package abtest
+
+import (
+	"sync/atomic"
+
+	logger "github.com/phoenix-rec/abtest-sdk-go/abtest/log"
+	abtest "github.com/phoenix-rec/abtest-sdk-go/abtest/proto"
+)
+
+// Get returns the value of keyName in the config of id in the experiment of the default client,
+// converted to T, or defaultValue if it is missing or does not convert, see GetFrom.
+func Get[T any](id, expName, keyName string, defaultValue T) T {
+	return GetFrom(defaultClient, id, expName, keyName, defaultValue)
+}
+
+// GetFrom is like Get, reading from c.
+func GetFrom[T any](c *Client, id, expName, keyName string, defaultValue T) (val T) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return defaultValue
+	}
+
+	if err := c.ab.GetKeyContext(c.context(), id, expName, keyName, &val); err != nil && err != ErrExperimentExpired {
+		c.ab.TrackError("Get", id, expName, keyName, err)
+		val = defaultValue
+	}
+
+	return
+}
+
+// Flag is a handle on a key of an experiment, declared once and read many times:
+//
+//	var rankerVersion = abtest.NewFlag("ranker_exp", "version", int64(1))
+//	...
+//	v := rankerVersion.Get(userId)
+//
+// The experiment and the value of the key in every strategy are looked up
+// once per version of the configs, so reading a flag only resolves the strategy of id.
+// Values of reference types, e.g. slices and maps, are shared by all readers and must not be modified.
+// A Flag is safe for concurrent use.
+type Flag[T any] struct {
+	expName      string
+	keyName      string
+	defaultValue T
+
+	cache atomic.Value // *flagCache[T]
+}
+
+// flagCache is what a Flag knows about a version of the configs.
+type flagCache[T any] struct {
+	view *view
+	p    *projectView
+	exp  *experimentView
+	err  error // why the experiment is not served
+
+	values map[string]T     // strategy_name => value of the key
+	errs   map[string]error // strategy_name => why the key is not served
+}
+
+// NewFlag returns a handle on keyName of expName, which reads defaultValue
+// whenever the key is missing or does not convert to T.
+func NewFlag[T any](expName, keyName string, defaultValue T) *Flag[T] {
+	return &Flag[T]{
+		expName:      expName,
+		keyName:      keyName,
+		defaultValue: defaultValue,
+	}
+}
+
+// Get returns the value of the flag for id from the default client.
+func (f *Flag[T]) Get(id string) T {
+	return f.GetFrom(defaultClient, id)
+}
+
+// GetFrom returns the value of the flag for id from c.
+// Reading a flag from several clients in turn invalidates its cache at every switch.
+func (f *Flag[T]) GetFrom(c *Client, id string) T {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return f.defaultValue
+	}
+
+	val, err := f.get(c, id)
+	if err != nil && err != ErrExperimentExpired {
+		c.ab.TrackError("Flag", id, f.expName, f.keyName, err)
+		return f.defaultValue
+	}
+
+	return val
+}
+
+func (f *Flag[T]) get(c *Client, id string) (val T, err error) {
+	if !c.ab.isRunning() {
+		err = ErrClientStopped
+		return
+	}
+
+	v := c.ab.loadView()
+	cache, _ := f.cache.Load().(*flagCache[T])
+	if cache == nil || cache.view != v {
+		cache = f.compile(c.ab, v)
+		f.cache.Store(cache)
+	}
+	if cache.err != nil {
+		err = cache.err
+		return
+	}
+
+	detail, err := c.ab.resolve(c.context(), cache.p, cache.exp.info, id)
+	if err != nil && err != ErrExperimentExpired {
+		return
+	}
+
+	if e, ok := cache.errs[detail.Strategy]; ok {
+		err = e
+		return
+	}
+	if val, ok := cache.values[detail.Strategy]; ok {
+		return val, err
+	}
+
+	err = ErrKeyNotFound
+	return
+}
+
+// compile decodes the key in every strategy of the experiment in v.
+func (f *Flag[T]) compile(c *ABClient, v *view) (cache *flagCache[T]) {
+	cache = &flagCache[T]{view: v}
+
+	if v == nil {
+		cache.err = ErrClientUninitialized
+		return
+	}
+
+	p, ok := v.projects[c.projectId]
+	if !ok {
+		cache.err = ErrProjectNotFound
+		return
+	}
+	cache.p = p
+
+	exp, ok := p.experiments[f.expName]
+	if !ok {
+		cache.err = ErrExperimentNotFound
+		return
+	}
+	cache.exp = exp
+
+	if exp.info.Status == abtest.Disabled {
+		cache.err = ErrExperimentDisabled
+		return
+	}
+
+	cache.values = make(map[string]T, len(exp.configs))
+	cache.errs = make(map[string]error)
+	for strategyName, config := range exp.configs {
+		var val T
+		if err := config.decodeKey(f.keyName, &val); err != nil {
+			cache.errs[strategyName] = err
+			continue
+		}
+		cache.values[strategyName] = val
+	}
+
+	return
+}
@@ -0,0 +1,163 @@
+package abtest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// keyPaths caches the valid compiled key paths, key => *keyPath,
+// up to maxKeyPaths of them so keys built on the fly cannot grow it without bound.
+var (
+	keyPaths     sync.Map
+	keyPathCount int64
+)
+
+const maxKeyPaths = 4096
+
+// keyPath is a compiled path into nested config values, e.g. ranker.weights.ctr or slots[2].size.
+type keyPath struct {
+	key      string
+	segments []pathSegment
+	err      error // the syntax error of the key
+}
+
+type pathSegment struct {
+	name  string // the key of an object, if index < 0
+	index int    // the index of an array
+}
+
+func (s pathSegment) String() string {
+	if s.index >= 0 {
+		return "[" + strconv.Itoa(s.index) + "]"
+	}
+	return s.name
+}
+
+func isKeyPath(key string) bool {
+	return strings.ContainsAny(key, ".[")
+}
+
+func getKeyPath(key string) *keyPath {
+	if p, ok := keyPaths.Load(key); ok {
+		return p.(*keyPath)
+	}
+
+	p := compileKeyPath(key)
+	if p.err != nil || atomic.LoadInt64(&keyPathCount) >= maxKeyPaths {
+		return p
+	}
+
+	cached, loaded := keyPaths.LoadOrStore(key, p)
+	if !loaded {
+		atomic.AddInt64(&keyPathCount, 1)
+	}
+	return cached.(*keyPath)
+}
+
+func compileKeyPath(key string) (p *keyPath) {
+	p = &keyPath{key: key}
+
+	syntaxErr := func(pos int) *keyPath {
+		p.segments = nil
+		p.err = fmt.Errorf("invalid key path %q at offset %d", key, pos)
+		return p
+	}
+
+	for i := 0; i < len(key); {
+		switch {
+		case key[i] == '[':
+			end := strings.IndexByte(key[i:], ']')
+			if end < 0 {
+				return syntaxErr(i)
+			}
+			index, err := strconv.Atoi(key[i+1 : i+end])
+			if err != nil || index < 0 {
+				return syntaxErr(i + 1)
+			}
+			p.segments = append(p.segments, pathSegment{index: index})
+			i += end + 1
+		case key[i] == '.' && len(p.segments) > 0:
+			i++
+			fallthrough
+		default:
+			if i > 0 && key[i-1] != '.' {
+				return syntaxErr(i)
+			}
+			end := strings.IndexAny(key[i:], ".[")
+			if end < 0 {
+				end = len(key) - i
+			}
+			if end == 0 {
+				return syntaxErr(i)
+			}
+			p.segments = append(p.segments, pathSegment{name: key[i : i+end], index: -1})
+			i += end
+		}
+	}
+
+	return
+}
+
+// resolve walks the path in values.
+func (p *keyPath) resolve(values map[string]interface{}) (val interface{}, err error) {
+	if p.err != nil {
+		err = p.err
+		return
+	}
+
+	val = values
+	for i, segment := range p.segments {
+		var ok bool
+		if segment.index < 0 {
+			var m map[string]interface{}
+			if m, ok = val.(map[string]interface{}); ok {
+				val, ok = m[segment.name]
+			}
+		} else {
+			var a []interface{}
+			if a, ok = val.([]interface{}); ok && segment.index < len(a) {
+				val = a[segment.index]
+			} else {
+				ok = false
+			}
+		}
+
+		if !ok {
+			err = &KeyNotFoundError{Key: p.key, Segment: p.segments[i].String()}
+			return
+		}
+	}
+
+	return
+}
+
+// get returns the value of key, which is either a key of the config or a path into its values.
+// A missing key returns ErrKeyNotFound, and a path not found a *KeyNotFoundError.
+func (cv *configView) get(key string) (val interface{}, err error) {
+	if val, ok := cv.values[key]; ok {
+		return val, nil
+	}
+
+	if !isKeyPath(key) {
+		err = ErrKeyNotFound
+		return
+	}
+	return getKeyPath(key).resolve(cv.values)
+}
+
+// getTyped returns the scalar value of key decoded ahead of time,
+// decoding the value at the end of a path on the fly.
+func (cv *configView) getTyped(key string) (t typedValue, ok bool) {
+	if t, ok = cv.typed[key]; ok || !isKeyPath(key) {
+		return
+	}
+
+	val, err := getKeyPath(key).resolve(cv.values)
+	if err != nil {
+		return
+	}
+	return decodeTyped(val)
+}
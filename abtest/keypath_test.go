@@ -0,0 +1,33 @@
+package abtest
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func TestKeyPathCacheBounded(t *testing.T) {
+	getKeyPath("slots[x]")
+	if _, ok := keyPaths.Load("slots[x]"); ok {
+		t.Error("invalid key path cached")
+	}
+
+	// Leave room in the cache for the other tests.
+	defer func() {
+		for i := 0; i < 2*maxKeyPaths; i++ {
+			key := fmt.Sprintf("ranker.w%d", i)
+			if _, ok := keyPaths.LoadAndDelete(key); ok {
+				atomic.AddInt64(&keyPathCount, -1)
+			}
+		}
+	}()
+
+	for i := 0; i < 2*maxKeyPaths; i++ {
+		if p := getKeyPath(fmt.Sprintf("ranker.w%d", i)); p.err != nil || len(p.segments) != 2 {
+			t.Fatalf("got %+v", p)
+		}
+	}
+	if n := atomic.LoadInt64(&keyPathCount); n > maxKeyPaths {
+		t.Errorf("got %d cached key paths, want at most %d", n, maxKeyPaths)
+	}
+}
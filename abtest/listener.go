@@ -0,0 +1,288 @@
+package abtest
+
+import (
+	"sort"
+	"sync"
+
+	logger "github.com/phoenix-rec/abtest-sdk-go/abtest/log"
+	abtest "github.com/phoenix-rec/abtest-sdk-go/abtest/proto"
+)
+
+const watchBufferSize = 16
+
+// ExperimentChange describes how a single experiment changed in an update.
+type ExperimentChange struct {
+	ProjectId int64
+	ExpName   string
+	OldUt     int64 // 0 if the experiment is added
+	NewUt     int64 // 0 if the experiment is removed
+}
+
+// ChangeEvent reports the experiments changed by an update of the local configs.
+type ChangeEvent struct {
+	Version int64 // version of the configs after the update
+
+	Added    []ExperimentChange
+	Changed  []ExperimentChange
+	Disabled []ExperimentChange
+	Removed  []ExperimentChange
+}
+
+func (e *ChangeEvent) IsEmpty() bool {
+	return len(e.Added) == 0 && len(e.Changed) == 0 && len(e.Disabled) == 0 && len(e.Removed) == 0
+}
+
+// filter returns the changes of a single experiment.
+func (e *ChangeEvent) filter(projectId int64, expName string) (event ChangeEvent) {
+	match := func(changes []ExperimentChange) (result []ExperimentChange) {
+		for _, change := range changes {
+			if change.ProjectId == projectId && change.ExpName == expName {
+				result = append(result, change)
+			}
+		}
+		return
+	}
+
+	event.Version = e.Version
+	event.Added = match(e.Added)
+	event.Changed = match(e.Changed)
+	event.Disabled = match(e.Disabled)
+	event.Removed = match(e.Removed)
+	return
+}
+
+// diffInfoMap compares two versions of the configs.
+// An experiment counts as changed when its ut changes, and as disabled
+// rather than changed when its status turns to Disabled.
+func diffInfoMap(oldInfoMap, newInfoMap map[int64]map[string]*abtest.ExperimentInfo) (event ChangeEvent) {
+	for projectId, newExpMap := range newInfoMap {
+		oldExpMap := oldInfoMap[projectId]
+		for expName, info := range newExpMap {
+			old, ok := oldExpMap[expName]
+			change := ExperimentChange{ProjectId: projectId, ExpName: expName, NewUt: info.Ut}
+			switch {
+			case !ok:
+				event.Added = append(event.Added, change)
+			case old == info:
+			case info.Status == abtest.Disabled && old.Status != abtest.Disabled:
+				change.OldUt = old.Ut
+				event.Disabled = append(event.Disabled, change)
+			case info.Ut != old.Ut || info.Status != old.Status:
+				change.OldUt = old.Ut
+				event.Changed = append(event.Changed, change)
+			}
+		}
+	}
+
+	for projectId, oldExpMap := range oldInfoMap {
+		newExpMap := newInfoMap[projectId]
+		for expName, old := range oldExpMap {
+			if _, ok := newExpMap[expName]; !ok {
+				event.Removed = append(event.Removed, ExperimentChange{ProjectId: projectId, ExpName: expName, OldUt: old.Ut})
+			}
+		}
+	}
+
+	for _, changes := range [][]ExperimentChange{event.Added, event.Changed, event.Disabled, event.Removed} {
+		sort.Slice(changes, func(i, j int) bool {
+			if changes[i].ProjectId != changes[j].ProjectId {
+				return changes[i].ProjectId < changes[j].ProjectId
+			}
+			return changes[i].ExpName < changes[j].ExpName
+		})
+	}
+
+	return
+}
+
+type listeners struct {
+	m        sync.Mutex
+	nextId   int
+	onChange map[int]func(ChangeEvent)
+	watchers map[int]*watcher
+	closed   bool
+
+	qm          sync.Mutex // guards the fields below
+	queue       []ChangeEvent
+	dispatching bool
+}
+
+// enqueue queues event for notify. c.m must be held, so events are queued in the order of the updates.
+func (l *listeners) enqueue(event ChangeEvent) {
+	if event.IsEmpty() {
+		return
+	}
+
+	l.qm.Lock()
+	l.queue = append(l.queue, event)
+	l.qm.Unlock()
+}
+
+// next returns the next queued event, or false once the queue is empty and dispatching ends.
+func (l *listeners) next() (event ChangeEvent, ok bool) {
+	l.qm.Lock()
+	defer l.qm.Unlock()
+
+	if len(l.queue) == 0 {
+		l.dispatching = false
+		return
+	}
+	event, l.queue = l.queue[0], l.queue[1:]
+	return event, true
+}
+
+// close stops all watches, later ones are stopped from the start.
+func (l *listeners) close() {
+	l.m.Lock()
+	watchers := l.watchers
+	l.watchers = nil
+	l.closed = true
+	l.m.Unlock()
+
+	for _, w := range watchers {
+		w.close()
+	}
+}
+
+type watcher struct {
+	expName string
+
+	m      sync.Mutex // guards ch against sending once closed
+	ch     chan ChangeEvent
+	closed bool
+}
+
+// send delivers event unless the watch is stopped or the receiver falls behind.
+func (w *watcher) send(event ChangeEvent) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.closed {
+		return
+	}
+
+	select {
+	case w.ch <- event:
+	default:
+		logger.WarnF("watcher of %s falls behind, change event dropped", w.expName)
+	}
+}
+
+func (w *watcher) close() {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if !w.closed {
+		w.closed = true
+		close(w.ch)
+	}
+}
+
+// OnChange calls fn after every update which changes the local configs, in the order of the updates.
+// fn runs on a goroutine performing an update and should return quickly.
+// The returned function unregisters fn.
+func (c *ABClient) OnChange(fn func(ChangeEvent)) (cancel func()) {
+	l := &c.listeners
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.onChange == nil {
+		l.onChange = make(map[int]func(ChangeEvent))
+	}
+	id := l.nextId
+	l.nextId++
+	l.onChange[id] = fn
+
+	return func() {
+		l.m.Lock()
+		defer l.m.Unlock()
+
+		delete(l.onChange, id)
+	}
+}
+
+// Watch returns a channel receiving the changes of expName in the client's project.
+// Events are dropped if the receiver falls behind by more than watchBufferSize events.
+// The returned function stops the watch and closes the channel, as does closing the client.
+func (c *ABClient) Watch(expName string) (ch <-chan ChangeEvent, cancel func()) {
+	l := &c.listeners
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	w := &watcher{expName: expName, ch: make(chan ChangeEvent, watchBufferSize)}
+	if l.closed {
+		w.close()
+		return w.ch, func() {}
+	}
+
+	if l.watchers == nil {
+		l.watchers = make(map[int]*watcher)
+	}
+	id := l.nextId
+	l.nextId++
+	l.watchers[id] = w
+
+	return w.ch, func() {
+		l.m.Lock()
+		delete(l.watchers, id)
+		l.m.Unlock()
+
+		w.close()
+	}
+}
+
+// notify delivers the queued events in order. Only one goroutine delivers at a time,
+// the others return and leave their events to it.
+// notify must be called without holding c.m, so listeners may read the new configs.
+// The listeners are called without holding the lock of the listeners either,
+// so they may register and unregister listeners, including themselves.
+func (c *ABClient) notify() {
+	l := &c.listeners
+	l.qm.Lock()
+	if l.dispatching {
+		l.qm.Unlock()
+		return
+	}
+	l.dispatching = true
+	l.qm.Unlock()
+
+	for event, ok := l.next(); ok; event, ok = l.next() {
+		c.dispatch(event)
+	}
+}
+
+func (c *ABClient) dispatch(event ChangeEvent) {
+	l := &c.listeners
+	l.m.Lock()
+	onChange := make([]func(ChangeEvent), 0, len(l.onChange))
+	for _, fn := range l.onChange {
+		onChange = append(onChange, fn)
+	}
+	watchers := make([]*watcher, 0, len(l.watchers))
+	for _, w := range l.watchers {
+		watchers = append(watchers, w)
+	}
+	l.m.Unlock()
+
+	for _, fn := range onChange {
+		c.callListener(fn, event)
+	}
+
+	for _, w := range watchers {
+		expEvent := event.filter(c.projectId, w.expName)
+		if expEvent.IsEmpty() {
+			continue
+		}
+		w.send(expEvent)
+	}
+}
+
+func (c *ABClient) callListener(fn func(ChangeEvent), event ChangeEvent) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.ErrorF("change listener err: %v", err)
+		}
+	}()
+
+	fn(event)
+}
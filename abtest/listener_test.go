@@ -0,0 +1,160 @@
+package abtest
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	proto "github.com/phoenix-rec/abtest-sdk-go/abtest/proto"
+)
+
+func TestChangeListeners(t *testing.T) {
+	source := NewMemorySource(testConfigList(t, 1, 1, testExperiment("a", 1), testExperiment("b", 1), testExperiment("d", 1)))
+	c, err := NewClient(1, proto.WithConfigSource(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var events []ChangeEvent
+	cancel := c.OnChange(func(e ChangeEvent) { events = append(events, e) })
+	defer cancel()
+	ch, stop := c.Watch("a")
+	defer stop()
+
+	a, b := testExperiment("a", 2), testExperiment("b", 1)
+	a["ut"], b["ut"], b["status"] = 2, 2, -1
+	update := testConfigList(t, 2, 1, a, b, testExperiment("c", 1))
+	update.DeletedMap = map[int64][]string{1: {"d"}}
+	source.Set(update)
+	if err := c.ab.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := ChangeEvent{
+		Version:  2,
+		Added:    []ExperimentChange{{ProjectId: 1, ExpName: "c", NewUt: 1}},
+		Changed:  []ExperimentChange{{ProjectId: 1, ExpName: "a", OldUt: 1, NewUt: 2}},
+		Disabled: []ExperimentChange{{ProjectId: 1, ExpName: "b", OldUt: 1, NewUt: 2}},
+		Removed:  []ExperimentChange{{ProjectId: 1, ExpName: "d", OldUt: 1}},
+	}
+	if len(events) != 1 || !reflect.DeepEqual(events[0], want) {
+		t.Fatalf("got %+v, want %+v", events, want)
+	}
+
+	select {
+	case e := <-ch:
+		if len(e.Changed) != 1 || e.Changed[0].ExpName != "a" || len(e.Added) != 0 {
+			t.Errorf("unexpected watch event %+v", e)
+		}
+	default:
+		t.Error("no watch event")
+	}
+
+	// Nothing changed, nothing is sent.
+	if err := c.ab.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Errorf("got %d events, want 1", len(events))
+	}
+}
+
+func TestChangeListenersOrder(t *testing.T) {
+	source := NewMemorySource(testConfigList(t, 1, 1, testExperiment("exp", 1)))
+	c, err := NewClient(1, proto.WithConfigSource(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each event changes exp from the ut the previous one changed it to.
+	var m sync.Mutex
+	var last int64 = 1
+	var calls int
+	c.OnChange(func(e ChangeEvent) {
+		m.Lock()
+		defer m.Unlock()
+
+		calls++
+		if len(e.Changed) != 1 || e.Changed[0].OldUt != last {
+			t.Errorf("got %+v after ut %d, want events in the order of the updates", e.Changed, last)
+			return
+		}
+		last = e.Changed[0].NewUt
+	})
+	ch, _ := c.Watch("exp")
+
+	var ut int64 = 1
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				v := atomic.AddInt64(&ut, 1)
+				exp := testExperiment("exp", v)
+				exp["ut"] = v
+				c.ab.apply(testConfigList(t, v, 1, exp))
+			}
+		}()
+	}
+	wg.Wait()
+
+	m.Lock()
+	if calls != 80 {
+		t.Errorf("got %d events, want 80", calls)
+	}
+	m.Unlock()
+
+	c.Close()
+	for range ch {
+	}
+	ch, _ = c.Watch("exp")
+	if _, ok := <-ch; ok {
+		t.Error("got an open watch after Close")
+	}
+}
+
+func TestListenerSelfCancel(t *testing.T) {
+	source := NewMemorySource(testConfigList(t, 1, 1, testExperiment("a", 1)))
+	c, err := NewClient(1, proto.WithConfigSource(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var calls, nested int
+	var cancel func()
+	cancel = c.OnChange(func(ChangeEvent) {
+		calls++
+		cancel()
+		c.OnChange(func(ChangeEvent) { nested++ })
+	})
+	ch, stop := c.Watch("a")
+	c.OnChange(func(ChangeEvent) { stop() })
+
+	for ut := int64(2); ut <= 3; ut++ {
+		a := testExperiment("a", ut)
+		a["ut"] = ut
+		source.Set(testConfigList(t, ut, 1, a))
+
+		done := make(chan error)
+		go func() { done <- c.ab.Update() }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("update deadlocked on a listener unregistering itself")
+		}
+	}
+
+	if calls != 1 || nested != 1 {
+		t.Errorf("got %d calls and %d nested calls, want 1 and 1", calls, nested)
+	}
+	for range ch {
+	}
+}
@@ -0,0 +1,183 @@
+package abtest
+
+import (
+	"context"
+
+	logger "github.com/phoenix-rec/abtest-sdk-go/abtest/log"
+	abtest "github.com/phoenix-rec/abtest-sdk-go/abtest/proto"
+)
+
+// GetParam decodes the value of keyName for id into result without naming the experiment,
+// and returns the experiment it is from. keyName is looked up in the parameter index of the project:
+// the first enabled experiment by priority whose strategy of id sets the key serves it,
+// so GetParam agrees with GetConfig. Under abtest.ConflictError, a key served
+// by several experiments returns a *ConfigConflictError instead.
+// A key path, e.g. ranker.weights.ctr, is looked up by its first segment.
+func (c *ABClient) GetParam(id, keyName string, result interface{}) (expName string, err error) {
+	return c.GetParamContext(context.Background(), id, keyName, result)
+}
+
+// GetParamContext is like GetParam, evaluating for the request in ctx, see ABClient.
+func (c *ABClient) GetParamContext(ctx context.Context, id, keyName string, result interface{}) (expName string, err error) {
+	config, expName, err := c.lookupParam(ctx, id, keyName)
+	if err != nil && err != ErrExperimentExpired {
+		return
+	}
+
+	if keyErr := config.decodeKey(keyName, result); keyErr != nil {
+		err = keyErr
+	}
+	return
+}
+
+// ParamOwners returns the enabled experiments setting keyName in any strategy, by priority.
+func (c *ABClient) ParamOwners(keyName string) (expNames []string, err error) {
+	p, err := c.project()
+	if err != nil {
+		return
+	}
+
+	for _, e := range p.paramOwners(keyName) {
+		expNames = append(expNames, e.name)
+	}
+	return
+}
+
+// paramOwners returns the owners of keyName in the index, or else of the first segment of its path.
+func (p *projectView) paramOwners(keyName string) []*experimentView {
+	if owners, ok := p.params[keyName]; ok || !isKeyPath(keyName) {
+		return owners
+	}
+
+	path := getKeyPath(keyName)
+	if path.err != nil || path.segments[0].index >= 0 {
+		return nil
+	}
+	return p.params[path.segments[0].name]
+}
+
+// lookupParam resolves the owners of keyName for id in turn and returns the first config serving the key.
+// An expired experiment serves the default config along with ErrExperimentExpired.
+func (c *ABClient) lookupParam(ctx context.Context, id, keyName string) (config *configView, expName string, err error) {
+	if !c.isRunning() {
+		err = ErrClientStopped
+		return
+	}
+
+	p, err := c.project()
+	if err != nil {
+		return
+	}
+
+	config = emptyConfigView
+	err = ErrKeyNotFound
+	var found bool
+	var conflicts []string
+
+	for _, e := range p.paramOwners(keyName) {
+		detail, expErr := c.resolve(ctx, p, e.info, id)
+		if expErr != nil && expErr != ErrExperimentExpired {
+			if !found {
+				err = expErr
+			}
+			continue
+		}
+
+		cv := e.config(detail.Strategy)
+		if _, keyErr := cv.get(keyName); keyErr != nil {
+			continue
+		}
+
+		if found {
+			c.trackConflict(id, keyName, expName, e.name)
+			if len(conflicts) == 0 {
+				conflicts = []string{expName}
+			}
+			conflicts = append(conflicts, e.name)
+			continue
+		}
+
+		config, expName, err = cv, e.name, expErr
+		found = true
+		if c.options.ConflictPolicy != abtest.ConflictError {
+			return
+		}
+	}
+
+	if len(conflicts) > 0 {
+		config = emptyConfigView
+		err = &ConfigConflictError{Conflicts: map[string][]string{keyName: conflicts}}
+	}
+	return
+}
+
+// The typed getters below are GetParam into their type, see getInt64.
+
+func (c *ABClient) getParamBool(ctx context.Context, id, keyName string) (val bool, err error) {
+	config, _, err := c.lookupParam(ctx, id, keyName)
+	if err != nil && err != ErrExperimentExpired {
+		return
+	}
+
+	if t, ok := config.getTyped(keyName); ok && t.kinds&kindBool != 0 {
+		return t.b, err
+	}
+	return config.decodeBool(keyName, err)
+}
+
+func (c *ABClient) getParamString(ctx context.Context, id, keyName string) (val string, err error) {
+	config, _, err := c.lookupParam(ctx, id, keyName)
+	if err != nil && err != ErrExperimentExpired {
+		return
+	}
+
+	if t, ok := config.getTyped(keyName); ok && t.kinds&kindString != 0 {
+		return t.s, err
+	}
+	return config.decodeString(keyName, err)
+}
+
+func (c *ABClient) getParamInt64(ctx context.Context, id, keyName string) (val int64, err error) {
+	config, _, err := c.lookupParam(ctx, id, keyName)
+	if err != nil && err != ErrExperimentExpired {
+		return
+	}
+
+	if t, ok := config.getTyped(keyName); ok && t.kinds&kindInt64 != 0 {
+		return t.i, err
+	}
+	return config.decodeInt64(keyName, err)
+}
+
+func (c *ABClient) getParamFloat64(ctx context.Context, id, keyName string) (val float64, err error) {
+	config, _, err := c.lookupParam(ctx, id, keyName)
+	if err != nil && err != ErrExperimentExpired {
+		return
+	}
+
+	if t, ok := config.getTyped(keyName); ok && t.kinds&kindFloat64 != 0 {
+		return t.f, err
+	}
+	return config.decodeFloat64(keyName, err)
+}
+
+// Param returns the value of keyName for id in the default client, whichever experiment sets it,
+// converted to T, or defaultValue if it is missing or does not convert, see ABClient.GetParam.
+func Param[T any](id, keyName string, defaultValue T) T {
+	return ParamFrom(defaultClient, id, keyName, defaultValue)
+}
+
+// ParamFrom is like Param, reading from c.
+func ParamFrom[T any](c *Client, id, keyName string, defaultValue T) (val T) {
+	if c == nil {
+		logger.Error(ErrClientUninitialized)
+		return defaultValue
+	}
+
+	if _, err := c.ab.GetParamContext(c.context(), id, keyName, &val); err != nil && err != ErrExperimentExpired {
+		c.ab.TrackError("Param", id, "", keyName, err)
+		val = defaultValue
+	}
+
+	return
+}
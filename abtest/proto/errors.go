@@ -0,0 +1,8 @@
+package abtest
+
+import "errors"
+
+var (
+	ErrExperimentExpired = errors.New("experiment expired")
+	ErrExperimentInvalid = errors.New("experiment invalid")
+)
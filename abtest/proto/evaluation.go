@@ -0,0 +1,165 @@
+package abtest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/phoenix-rec/abtest-sdk-go/abtest/consts"
+	"github.com/phoenix-rec/abtest-sdk-go/abtest/utils"
+)
+
+// EvaluationReason tells why an id is served a strategy.
+type EvaluationReason = string
+
+const (
+	ReasonWhitelist          EvaluationReason = "WHITELIST"           // the id is in WhiteMap
+	ReasonBucketed           EvaluationReason = "BUCKETED"            // the id hashes into a partition of the strategy
+	ReasonSticky             EvaluationReason = "STICKY"              // the id keeps the strategy in the StickyStore
+	ReasonDefaultUnallocated EvaluationReason = "DEFAULT_UNALLOCATED" // the id hashes into no strategy
+	ReasonNotInLayer         EvaluationReason = "NOT_IN_LAYER"        // the id falls into a layer slot of another experiment
+	ReasonNotTargeted        EvaluationReason = "NOT_TARGETED"        // the user does not match the targeting rule
+	ReasonDisabled           EvaluationReason = "DISABLED"            // the experiment is disabled
+	ReasonExpired            EvaluationReason = "EXPIRED"             // the experiment has expired
+	ReasonError              EvaluationReason = "ERROR"               // the experiment is malformed or invalid
+)
+
+// IsAssigned reports whether reason puts the id into a strategy of the experiment,
+// rather than serving the default to an id outside of it.
+func IsAssigned(reason EvaluationReason) bool {
+	switch reason {
+	case ReasonWhitelist, ReasonBucketed, ReasonSticky:
+		return true
+	}
+	return false
+}
+
+// EvaluationDetail describes how the strategy of an id is resolved.
+type EvaluationDetail struct {
+	Strategy  string
+	Reason    EvaluationReason
+	Partition int64 // partition the id hashes into, -1 if not hashed
+	ExpID     string
+	Ut        int64
+}
+
+// EvalContext holds the state shared by the experiments of a client during evaluation.
+type EvalContext struct {
+	// Sticky records the strategies of sticky experiments, nil to disable stickiness.
+	Sticky StickyStore
+
+	// Layers is the slot allocation of the project, built by BuildLayers.
+	// If nil, a layered experiment only checks its own slots.
+	Layers map[string]*Layer
+
+	// User holds the attributes matched against the targeting rules.
+	// It is ignored when evaluating another id, leaving the attributes unknown.
+	User *User
+}
+
+// GetStrategyDetail resolves the strategy of id.
+// Every outcome other than WHITELIST, STICKY and BUCKETED serves the default strategy.
+func (i *ExperimentInfo) GetStrategyDetail(id string) (detail EvaluationDetail, err error) {
+	return i.Evaluate(id, nil)
+}
+
+// GetStrategyDetailWithStore is like GetStrategyDetail, but if the experiment is sticky,
+// an id is served the strategy recorded in store before being hashed,
+// and the strategy it is hashed into is recorded.
+func (i *ExperimentInfo) GetStrategyDetailWithStore(id string, store StickyStore) (detail EvaluationDetail, err error) {
+	return i.Evaluate(id, &EvalContext{Sticky: store})
+}
+
+// Evaluate resolves the strategy of id within ec, which may be nil.
+func (i *ExperimentInfo) Evaluate(id string, ec *EvalContext) (detail EvaluationDetail, err error) {
+	if ec == nil {
+		ec = &EvalContext{}
+	}
+
+	detail = EvaluationDetail{
+		Strategy:  consts.DefaultStrategyName,
+		Reason:    ReasonDefaultUnallocated,
+		Partition: -1,
+		ExpID:     i.ExpID,
+		Ut:        i.Ut,
+	}
+
+	if i.Status == Disabled {
+		detail.Reason = ReasonDisabled
+		return
+	}
+
+	if i.Invalid != nil {
+		detail.Reason = ReasonError
+		err = ErrExperimentInvalid
+		return
+	}
+
+	if i.IsExpired(time.Now()) {
+		detail.Reason = ReasonExpired
+		err = ErrExperimentExpired
+		return
+	}
+
+	if i.WhiteMap == nil {
+		detail.Reason = ReasonError
+		err = fmt.Errorf("whiteMap is nil")
+		return
+	}
+
+	if strategyName, ok := i.WhiteMap[id]; ok {
+		detail.Reason = ReasonWhitelist
+		if len(strategyName) > 0 {
+			detail.Strategy = strategyName
+		}
+		return
+	}
+
+	if i.Targeting != nil {
+		user := ec.User
+		if user == nil || user.Id != id {
+			user = &User{Id: id}
+		}
+		if !i.Targeting.Match(user) {
+			detail.Reason = ReasonNotTargeted
+			return
+		}
+	}
+
+	if !i.inLayer(id, ec.Layers) {
+		detail.Reason = ReasonNotInLayer
+		return
+	}
+
+	store := ec.Sticky
+	sticky := i.Sticky && store != nil
+	if sticky {
+		// A strategy removed from the experiment no longer holds the id.
+		if strategyName, ok := store.Get(id, i.ExpID); ok && i.hasStrategy(strategyName) {
+			detail.Strategy = strategyName
+			detail.Reason = ReasonSticky
+			return
+		}
+	}
+
+	if i.PartitionCount > 0 && int(i.PartitionCount) == len(i.StrategyNameTable) {
+		index := utils.HashIndexBy(i.Hash, i.ExpID, id, i.PartitionCount)
+		detail.Partition = int64(index)
+		if strategyName := i.StrategyNameTable[index]; len(strategyName) > 0 {
+			detail.Strategy = strategyName
+			detail.Reason = ReasonBucketed
+			if sticky {
+				store.Put(id, i.ExpID, strategyName)
+			}
+		}
+	}
+
+	return
+}
+
+func (i *ExperimentInfo) hasStrategy(strategyName string) bool {
+	if _, ok := i.PartitionsMap[strategyName]; ok {
+		return true
+	}
+	_, ok := i.ConfigMap[strategyName]
+	return ok
+}
@@ -0,0 +1,60 @@
+package abtest
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/phoenix-rec/abtest-sdk-go/abtest/utils"
+)
+
+func TestExperimentHash(t *testing.T) {
+	for _, alg := range []string{"", utils.HashMD5, utils.HashMurmur3, utils.HashXXHash} {
+		info := new(ExperimentInfo)
+		data := `{"exp_id": "exp_id", "name": "exp", "hash": "` + alg + `", "partition_count": 100,
+			"partitions_map": {"a": "0-49", "b": "50-99"}}`
+		if err := json.Unmarshal([]byte(data), info); err != nil || info.Invalid != nil {
+			t.Fatalf("%s: %v, %v", alg, err, info.Invalid)
+		}
+		for _, id := range []string{"u1", "u2", "u3"} {
+			detail, _ := info.GetStrategyDetail(id)
+			if want := utils.HashIndexBy(alg, "exp_id", id, 100); detail.Partition != int64(want) {
+				t.Errorf("%s %s: got partition %d, want %d", alg, id, detail.Partition, want)
+			}
+		}
+	}
+
+	info := new(ExperimentInfo)
+	if err := json.Unmarshal([]byte(`{"name": "exp", "hash": "sha1"}`), info); err != nil || info.Invalid == nil {
+		t.Errorf("unknown hash: %v, %v", err, info.Invalid)
+	}
+}
+
+func TestZeroLayerSlots(t *testing.T) {
+	info := new(ExperimentInfo)
+	data := `{"name": "exp", "status": 1, "domain": "rec", "layer": "rank", "layer_slot_count": 0,
+		"partition_count": 100, "partitions_map": {"treat": "0-99"}}`
+	if err := json.Unmarshal([]byte(data), info); err != nil {
+		t.Fatal(err)
+	}
+
+	layers, _ := BuildLayers(map[string]*ExperimentInfo{"exp": info})
+	if len(layers) != 0 {
+		t.Errorf("got layers %v from an invalid experiment", layers)
+	}
+	if detail, err := info.Evaluate("u1", &EvalContext{Layers: layers}); err != ErrExperimentInvalid || detail.Reason != ReasonError {
+		t.Errorf("got %+v, %v", detail, err)
+	}
+
+	// Layers built by hand are checked too.
+	info.Invalid = nil
+	layers = map[string]*Layer{"rec/rank": {Key: "rec/rank"}}
+	if owner := layers["rec/rank"].Owner("u1"); owner != "" {
+		t.Errorf("got owner %s of an empty layer", owner)
+	}
+	if detail, _ := info.Evaluate("u1", &EvalContext{Layers: layers}); detail.Reason != ReasonNotInLayer {
+		t.Errorf("got %+v, want NOT_IN_LAYER", detail)
+	}
+	if detail, _ := info.Evaluate("u1", &EvalContext{}); detail.Reason != ReasonNotInLayer {
+		t.Errorf("got %+v without layers, want NOT_IN_LAYER", detail)
+	}
+}
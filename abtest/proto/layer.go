@@ -0,0 +1,124 @@
+package abtest
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/phoenix-rec/abtest-sdk-go/abtest/utils"
+)
+
+// Layer splits the traffic of a layer into slots shared by mutually exclusive experiments.
+// The slot of an id in a layer is independent of its slots in other layers
+// and of its partitions in the experiments, so layers are orthogonal.
+type Layer struct {
+	Key       string
+	SlotCount uint64
+
+	// slot => name of the experiment occupying it, "" if free
+	Owners []string
+}
+
+func LayerKey(domain, layer string) string {
+	return domain + "/" + layer
+}
+
+// LayerKey returns the key of the layer of the experiment, "" if it is not layered.
+func (i *ExperimentInfo) LayerKey() string {
+	if len(i.Layer) == 0 {
+		return ""
+	}
+
+	return LayerKey(i.Domain, i.Layer)
+}
+
+// Slot returns the slot of id in the layer, 0 if the layer has no slots.
+func (l *Layer) Slot(id string) uint64 {
+	return layerSlot(l.Key, id, l.SlotCount)
+}
+
+// Owner returns the experiment occupying the slot of id in the layer, "" if the slot is free.
+func (l *Layer) Owner(id string) string {
+	if l.SlotCount == 0 || uint64(len(l.Owners)) != l.SlotCount {
+		return ""
+	}
+	return l.Owners[l.Slot(id)]
+}
+
+func layerSlot(key, id string, slotCount uint64) uint64 {
+	if slotCount == 0 {
+		return 0
+	}
+	return utils.HashIndex("layer:"+key, id, slotCount)
+}
+
+// inLayer reports whether id falls into the experiment within its layer.
+// Without layers, the experiment is checked against its own slots only.
+func (i *ExperimentInfo) inLayer(id string, layers map[string]*Layer) bool {
+	key := i.LayerKey()
+	if len(key) == 0 {
+		return true
+	}
+
+	if l, ok := layers[key]; ok {
+		return l.Owner(id) == i.Name
+	}
+
+	if i.LayerSlotCount == 0 || uint64(len(i.LayerSlotTable)) != i.LayerSlotCount {
+		return false
+	}
+	return i.LayerSlotTable[layerSlot(key, id, i.LayerSlotCount)]
+}
+
+// BuildLayers allocates the slots of every layer to the enabled, valid experiments in infoMap.
+// Conflicting claims are returned as errs and resolved deterministically,
+// so no two experiments of a layer ever share a slot:
+// a slot goes to the experiment first by name, and an experiment whose slot count
+// differs from the layer's is left out of the layer.
+func BuildLayers(infoMap map[string]*ExperimentInfo) (layers map[string]*Layer, errs []error) {
+	layers = make(map[string]*Layer)
+
+	names := make([]string, 0, len(infoMap))
+	for name, info := range infoMap {
+		if len(info.Layer) > 0 && info.Status != Disabled && info.Invalid == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		info := infoMap[name]
+		key := info.LayerKey()
+
+		l, ok := layers[key]
+		if !ok {
+			l = &Layer{
+				Key:       key,
+				SlotCount: info.LayerSlotCount,
+				Owners:    make([]string, info.LayerSlotCount),
+			}
+			layers[key] = l
+		}
+
+		if info.LayerSlotCount != l.SlotCount {
+			errs = append(errs, fmt.Errorf("layer %s: experiment %s has %d slots, want %d", key, name, info.LayerSlotCount, l.SlotCount))
+			continue
+		}
+
+		var conflicts []string
+		for slot, claimed := range info.LayerSlotTable {
+			if !claimed {
+				continue
+			}
+			if owner := l.Owners[slot]; len(owner) > 0 {
+				conflicts = append(conflicts, fmt.Sprintf("%d@%s", slot, owner))
+				continue
+			}
+			l.Owners[slot] = name
+		}
+		if len(conflicts) > 0 {
+			errs = append(errs, fmt.Errorf("layer %s: experiment %s overlaps slots %v", key, name, conflicts))
+		}
+	}
+
+	return
+}
@@ -0,0 +1,28 @@
+package abtest
+
+// MergePatch applies patch to target with the JSON merge patch semantics of RFC 7396:
+// objects merge key by key, a null value removes the key, and anything else replaces it.
+// Neither argument is modified, though the result may share values with them.
+func MergePatch(target, patch map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(target)+len(patch))
+	for k, v := range target {
+		result[k] = v
+	}
+
+	for k, v := range patch {
+		if v == nil {
+			delete(result, k)
+			continue
+		}
+
+		if p, ok := v.(map[string]interface{}); ok {
+			t, _ := result[k].(map[string]interface{})
+			result[k] = MergePatch(t, p)
+			continue
+		}
+
+		result[k] = v
+	}
+
+	return result
+}
@@ -0,0 +1,43 @@
+package abtest
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	// Cases from RFC 7396, appendix A.
+	for _, test := range []struct {
+		target, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	} {
+		var target, patch, want map[string]interface{}
+		for _, s := range []struct {
+			json string
+			m    *map[string]interface{}
+		}{{test.target, &target}, {test.patch, &patch}, {test.want, &want}} {
+			if err := json.Unmarshal([]byte(s.json), s.m); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		original, _ := json.Marshal(target)
+		if got := MergePatch(target, patch); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s + %s: got %v, want %s", test.target, test.patch, got, test.want)
+		}
+		if after, _ := json.Marshal(target); string(after) != string(original) {
+			t.Errorf("%s + %s: target modified to %s", test.target, test.patch, after)
+		}
+	}
+}
@@ -2,9 +2,11 @@ package abtest
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/phoenix-rec/abtest-sdk-go/abtest/consts"
+	"github.com/phoenix-rec/abtest-sdk-go/abtest/exposure"
 	"github.com/phoenix-rec/abtest-sdk-go/abtest/utils"
 	"time"
 )
@@ -23,11 +25,74 @@ const (
 	Disabled ExperimentStatus = -1
 )
 
+// ConflictPolicy tells how GetConfig merges a key set by several experiments.
+type ConflictPolicy string
+
+const (
+	ConflictPriority  ConflictPolicy = ""          // the experiment of the highest Priority wins, then the first by name
+	ConflictError     ConflictPolicy = "error"     // the key is dropped and a conflict error returned
+	ConflictNamespace ConflictPolicy = "namespace" // every key is prefixed with the name of its experiment, e.g. exp.key
+)
+
+// CheckConflictPolicy returns an error if policy is not supported.
+func CheckConflictPolicy(policy ConflictPolicy) error {
+	switch policy {
+	case ConflictPriority, ConflictError, ConflictNamespace:
+		return nil
+	}
+	return fmt.Errorf("unknown conflict policy %q", policy)
+}
+
 type Option func(*Options)
 
 type Options struct {
 	Hostport string
 	Interval int
+
+	// SnapshotPath is the file the merged configs are persisted to after every
+	// successful update, and loaded from on startup. Empty disables snapshots.
+	SnapshotPath string
+
+	// Source supplies the configs. Defaults to the A/B server at Hostport.
+	Source ConfigSource
+
+	// Streaming keeps a stream open to the config source and applies updates as soon as
+	// they are pushed, falling back to polling at Interval while the stream is broken.
+	// It takes effect only if Source implements StreamingConfigSource.
+	Streaming bool
+
+	// StreamIdleTimeout breaks a stream without any update or heartbeat for that long,
+	// falling back to polling. Defaults to consts.DefaultStreamIdleTimeoutInSecond.
+	StreamIdleTimeout time.Duration
+
+	// FullResyncInterval in second. If positive, the local configs are periodically rebuilt
+	// from a full config list instead of merging increments, to correct any drift.
+	FullResyncInterval int
+
+	// ReadyTimeout makes opening the client block until the configs are loaded,
+	// failing if they are not loaded within ReadyTimeout. 0 opens without waiting.
+	ReadyTimeout time.Duration
+
+	// ExposureLogger receives an exposure whenever an id is assigned a strategy, see ExposureReasons.
+	// It is not closed along with the client.
+	ExposureLogger exposure.Logger
+
+	// ExposureReasons are the reasons of the evaluations logged as exposures.
+	// Nil logs the ids assigned to a strategy only, see IsAssigned, so ids outside of an experiment
+	// are not counted as exposed.
+	ExposureReasons []EvaluationReason
+
+	// ExposureFilter drops duplicated and unsampled exposures before they reach ExposureLogger.
+	ExposureFilter *exposure.Filter
+
+	// StickyStore keeps the strategies of the ids in the experiments with Sticky set.
+	StickyStore StickyStore
+
+	// InheritDefault makes every experiment behave as if InheritDefault is set on it.
+	InheritDefault bool
+
+	// ConflictPolicy merges the keys set by several experiments in GetConfig.
+	ConflictPolicy ConflictPolicy
 }
 
 func WithHostport(s string) Option {
@@ -42,6 +107,95 @@ func WithInterval(i int) Option {
 	}
 }
 
+// WithSnapshotPath keeps an on-disk snapshot of the configs at path,
+// so a restarted client can serve the last known configs while the A/B server is unreachable.
+func WithSnapshotPath(path string) Option {
+	return func(o *Options) {
+		o.SnapshotPath = path
+	}
+}
+
+// WithConfigSource replaces the A/B server with s as the origin of the configs.
+func WithConfigSource(s ConfigSource) Option {
+	return func(o *Options) {
+		o.Source = s
+	}
+}
+
+// WithStreaming receives config updates as soon as the config source pushes them
+// instead of waiting for the next poll.
+func WithStreaming() Option {
+	return func(o *Options) {
+		o.Streaming = true
+	}
+}
+
+// WithStreamIdleTimeout falls back to polling once the stream is silent for d.
+func WithStreamIdleTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.StreamIdleTimeout = d
+	}
+}
+
+// WithFullResyncInterval rebuilds the local configs from scratch every i seconds.
+func WithFullResyncInterval(i int) Option {
+	return func(o *Options) {
+		o.FullResyncInterval = i
+	}
+}
+
+// WithReadyTimeout blocks opening the client until the configs are loaded,
+// for at most d.
+func WithReadyTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.ReadyTimeout = d
+	}
+}
+
+// WithExposureLogger records which strategy every id is served to l.
+func WithExposureLogger(l exposure.Logger) Option {
+	return func(o *Options) {
+		o.ExposureLogger = l
+	}
+}
+
+// WithExposureReasons logs the evaluations of the given reasons as exposures,
+// e.g. to also log ReasonDefaultUnallocated.
+func WithExposureReasons(reasons ...EvaluationReason) Option {
+	return func(o *Options) {
+		o.ExposureReasons = reasons
+	}
+}
+
+// WithExposureFilter de-duplicates and samples the exposures with f.
+func WithExposureFilter(f *exposure.Filter) Option {
+	return func(o *Options) {
+		o.ExposureFilter = f
+	}
+}
+
+// WithInheritDefault overlays the config of every strategy on the default config, see ExperimentInfo.InheritDefault.
+func WithInheritDefault() Option {
+	return func(o *Options) {
+		o.InheritDefault = true
+	}
+}
+
+// WithConflictPolicy merges the keys set by several experiments in GetConfig by policy.
+// NewClient fails on an unknown policy, see CheckConflictPolicy.
+func WithConflictPolicy(policy ConflictPolicy) Option {
+	return func(o *Options) {
+		o.ConflictPolicy = policy
+	}
+}
+
+// WithStickyStore keeps ids in their first strategy when a sticky experiment is reallocated.
+func WithStickyStore(s StickyStore) Option {
+	return func(o *Options) {
+		o.StickyStore = s
+	}
+}
+
 type ExperimentInfo struct {
 	ExpID          string           `json:"exp_id"`
 	Name           string           `json:"name"`
@@ -50,8 +204,33 @@ type ExperimentInfo struct {
 	PartitionCount uint64           `json:"partition_count"`
 	Status         ExperimentStatus `json:"status"`
 	Expire         int64            `json:"expire"`
+	Sticky         bool             `json:"sticky"`   // keep the first strategy of an id in the StickyStore
+	Hash           string           `json:"hash"`     // the utils.HashAlgorithm bucketing ids, "" for MD5
+	Priority       int64            `json:"priority"` // the higher wins the keys also set by other experiments
 	Version        int64            `json:"-"`
 
+	// InheritDefault overlays the config of every strategy on the default config,
+	// merged as a JSON merge patch, so a strategy only holds the keys it changes.
+	InheritDefault bool `json:"inherit_default"`
+
+	// Experiments in the same layer of a domain are mutually exclusive, each occupying
+	// the slots in LayerSlots out of LayerSlotCount. Experiments in different layers are orthogonal.
+	// An experiment without a layer receives all of the traffic.
+	Domain         string `json:"domain,omitempty"`
+	Layer          string `json:"layer,omitempty"`
+	LayerSlotCount uint64 `json:"layer_slot_count,omitempty"`
+	LayerSlots     string `json:"layer_slots,omitempty"`
+
+	// slot => whether the slot is in LayerSlots
+	LayerSlotTable []bool `json:"-"`
+
+	// Only the users matching Targeting take part in the experiment, nil for everyone.
+	Targeting *Rule `json:"targeting,omitempty"`
+
+	// Invalid is why the experiment failed to load, nil if it is valid.
+	// An invalid experiment serves the default strategy with ErrExperimentInvalid.
+	Invalid error `json:"-"`
+
 	// white_id => strategy_name
 	WhiteMap map[string]string `json:"white_map,omitempty"`
 
@@ -67,44 +246,59 @@ type ExperimentInfo struct {
 	StrategyNameTable []string `json:"-"`
 }
 
+// IsExpired reports whether the experiment has expired at now.
+// Expire is a unix timestamp in second, 0 means the experiment never expires.
+func (i *ExperimentInfo) IsExpired(now time.Time) bool {
+	return i.Expire > 0 && now.Unix() >= i.Expire
+}
+
+// GetStrategy returns the strategy of id, see GetStrategyDetail.
+// An expired experiment returns the default strategy along with ErrExperimentExpired.
 func (i *ExperimentInfo) GetStrategy(id string) (strategyName string, err error) {
-	defer func() {
-		if err == nil && len(strategyName) == 0 {
-			strategyName = consts.DefaultStrategyName
-		}
-	}()
+	detail, err := i.GetStrategyDetail(id)
+	return detail.Strategy, err
+}
 
-	if i.WhiteMap == nil {
-		err = fmt.Errorf("whiteMap is nil")
+func (i *ExperimentInfo) GetConfig(id string) (result map[string]interface{}, err error) {
+	result = make(map[string]interface{})
+
+	strategyName, err := i.GetStrategy(id)
+	if err != nil && err != ErrExperimentExpired {
 		return
 	}
 
-	strategyName, ok := i.WhiteMap[id]
-	if ok {
+	config, cfgErr := i.GetStrategyConfig(strategyName)
+	if cfgErr != nil {
+		err = cfgErr
 		return
 	}
 
-	if i.PartitionCount > 0 && int(i.PartitionCount) == len(i.StrategyNameTable) {
-		index := utils.HashIndex(i.ExpID, id, i.PartitionCount)
-		strategyName = i.StrategyNameTable[index]
+	for k, v := range config {
+		result[k] = v
 	}
 
 	return
 }
 
-func (i *ExperimentInfo) GetConfig(id string) (result map[string]interface{}, err error) {
-	result = make(map[string]interface{})
+// GetStrategyConfig returns a copy of the config of strategyName.
+func (i *ExperimentInfo) GetStrategyConfig(strategyName string) (result map[string]interface{}, err error) {
+	return i.StrategyConfig(strategyName, i.InheritDefault)
+}
 
-	strategyName, err := i.GetStrategy(id)
-	if err != nil {
-		return
-	}
+// StrategyConfig returns a copy of the config of strategyName,
+// overlaid on the default config if inherit is set.
+func (i *ExperimentInfo) StrategyConfig(strategyName string, inherit bool) (result map[string]interface{}, err error) {
+	result = make(map[string]interface{})
 
 	if i.ConfigMap == nil {
 		err = fmt.Errorf("configMap is nil")
 		return
 	}
 
+	if inherit && strategyName != consts.DefaultStrategyName {
+		return MergePatch(i.ConfigMap[consts.DefaultStrategyName], i.ConfigMap[strategyName]), nil
+	}
+
 	for k, v := range i.ConfigMap[strategyName] {
 		result[k] = v
 	}
@@ -148,7 +342,7 @@ func (i *ExperimentInfo) GetRawConfig(id string) (data []byte, err error) {
 	}
 
 	strategyName, err := i.GetStrategy(id)
-	if err != nil {
+	if err != nil && err != ErrExperimentExpired {
 		return
 	}
 	data = []byte(i.ConfigRawMap[strategyName])
@@ -157,17 +351,26 @@ func (i *ExperimentInfo) GetRawConfig(id string) (data []byte, err error) {
 }
 func (i *ExperimentInfo) MarshalJSON() ([]byte, error) {
 	return json.Marshal(map[string]interface{}{
-		"exp_id":          i.ExpID,
-		"name":            i.Name,
-		"exp_type":        i.ExpType,
-		"ut":              i.Ut,
-		"partition_count": i.PartitionCount,
-		"status":          i.Status,
-		"expire":          i.Expire,
-		"white_map":       i.WhiteMap,
-		"config_map":      i.ConfigMap,
-		"partitions_map":  i.PartitionsMap,
-		"config_raw_map":  i.ConfigRawMap,
+		"exp_id":           i.ExpID,
+		"name":             i.Name,
+		"exp_type":         i.ExpType,
+		"ut":               i.Ut,
+		"partition_count":  i.PartitionCount,
+		"status":           i.Status,
+		"expire":           i.Expire,
+		"sticky":           i.Sticky,
+		"hash":             i.Hash,
+		"priority":         i.Priority,
+		"inherit_default":  i.InheritDefault,
+		"domain":           i.Domain,
+		"layer":            i.Layer,
+		"layer_slot_count": i.LayerSlotCount,
+		"layer_slots":      i.LayerSlots,
+		"targeting":        i.Targeting,
+		"white_map":        i.WhiteMap,
+		"config_map":       i.ConfigMap,
+		"partitions_map":   i.PartitionsMap,
+		"config_raw_map":   i.ConfigRawMap,
 	})
 }
 
@@ -180,34 +383,52 @@ func (i *ExperimentInfo) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	// A malformed field invalidates the experiment rather than the whole config list.
+	i.Invalid = nil
+
 	expID, _ := m["exp_id"].(string)
 	i.ExpID = expID
 
 	name, _ := m["name"].(string)
 	i.Name = name
 
-	expType, _ := m["exp_type"].(json.Number).Int64()
-	i.ExpType = int(expType)
+	i.ExpType = int(i.number(m, "exp_type"))
+	i.Ut = i.number(m, "ut")
+	i.Status = int(i.number(m, "status"))
+	i.Expire = i.number(m, "expire")
+	i.Priority = i.number(m, "priority")
 
-	ut, _ := m["ut"].(json.Number).Int64()
-	i.Ut = ut
+	sticky, _ := m["sticky"].(bool)
+	i.Sticky = sticky
 
-	status, _ := m["status"].(json.Number).Int64()
-	i.Status = int(status)
+	inheritDefault, _ := m["inherit_default"].(bool)
+	i.InheritDefault = inheritDefault
 
-	expire, _ := m["expire"].(json.Number).Int64()
-	i.Expire = int64(expire)
+	hash, _ := m["hash"].(string)
+	i.Hash = hash
+	if err := utils.CheckHashAlgorithm(hash); err != nil {
+		i.invalidate(err)
+	}
 
 	i.Version = time.Now().UnixNano()
 
-	partitionCount, _ := m["partition_count"].(json.Number).Int64()
+	partitionCount := i.number(m, "partition_count")
+	if partitionCount < 0 {
+		i.invalidate(fmt.Errorf("partition_count: %d is negative", partitionCount))
+		partitionCount = 0
+	}
 	i.PartitionCount = uint64(partitionCount)
 
 	i.WhiteMap = make(map[string]string)
 	whiteMap, ok := m["white_map"].(map[string]interface{})
 	if ok {
 		for k, v := range whiteMap {
-			i.WhiteMap[k] = v.(string)
+			strategyName, ok := v.(string)
+			if !ok {
+				i.invalidate(fmt.Errorf("white_map: strategy of %s is not a string", k))
+				continue
+			}
+			i.WhiteMap[k] = strategyName
 		}
 	}
 
@@ -215,39 +436,124 @@ func (i *ExperimentInfo) UnmarshalJSON(data []byte) error {
 	configMap, ok := m["config_map"].(map[string]interface{})
 	if ok {
 		for k, v := range configMap {
-			i.ConfigMap[k] = v.(map[string]interface{})
+			config, ok := v.(map[string]interface{})
+			if !ok {
+				i.invalidate(fmt.Errorf("config_map: config of %s is not an object", k))
+				continue
+			}
+			i.ConfigMap[k] = config
 		}
 	}
 
 	i.ConfigRawMap = make(map[string]string)
 	cfgRawMap := &struct {
-		M map[string]string `json:"config_raw_map"`
+		M         map[string]string `json:"config_raw_map"`
+		Targeting json.RawMessage   `json:"targeting"`
 	}{}
 	if err := json.Unmarshal(data, cfgRawMap); err != nil {
-		return err
+		i.invalidate(err)
 	}
 	if cfgRawMap.M != nil {
 		i.ConfigRawMap = cfgRawMap.M
 	}
 
+	i.Targeting = nil
+	if len(cfgRawMap.Targeting) > 0 && string(cfgRawMap.Targeting) != "null" {
+		rule := new(Rule)
+		dec := json.NewDecoder(bytes.NewReader(cfgRawMap.Targeting))
+		dec.UseNumber()
+		err := dec.Decode(rule)
+		if err == nil {
+			err = rule.Compile()
+		}
+		if err != nil {
+			i.invalidate(fmt.Errorf("targeting: %v", err))
+		}
+		i.Targeting = rule
+	}
+
 	i.PartitionsMap = make(map[string]string)
 	i.StrategyNameTable = make([]string, i.PartitionCount)
 	partitionsMap, ok := m["partitions_map"].(map[string]interface{})
 	if ok {
 		pars := new(IntervalList)
 		for strategyName, v := range partitionsMap {
-			partitions := v.(string)
+			partitions, ok := v.(string)
+			if !ok {
+				i.invalidate(fmt.Errorf("partitions_map: partitions of %s is not a string", strategyName))
+				continue
+			}
 			i.PartitionsMap[strategyName] = partitions
-			pars.Init(partitions, partitionCount)
+			if err := pars.Init(partitions, partitionCount); err != nil {
+				i.invalidate(fmt.Errorf("partitions_map: partitions of %s: %v", strategyName, err))
+			}
 			for _, index := range pars.Array() {
 				i.StrategyNameTable[index] = strategyName
 			}
 		}
 	}
 
+	domain, _ := m["domain"].(string)
+	i.Domain = domain
+
+	layer, _ := m["layer"].(string)
+	i.Layer = layer
+
+	i.LayerSlotCount = consts.DefaultLayerSlotCount
+	if _, ok := m["layer_slot_count"]; ok {
+		slotCount := i.number(m, "layer_slot_count")
+		if slotCount < 0 {
+			i.invalidate(fmt.Errorf("layer_slot_count: %d is negative", slotCount))
+			slotCount = 0
+		}
+		i.LayerSlotCount = uint64(slotCount)
+	}
+	if len(i.Layer) > 0 && i.LayerSlotCount == 0 {
+		i.invalidate(fmt.Errorf("layer_slot_count: layer %s has no slots", i.Layer))
+	}
+
+	layerSlots, _ := m["layer_slots"].(string)
+	i.LayerSlots = layerSlots
+	i.LayerSlotTable = make([]bool, i.LayerSlotCount)
+	if len(i.Layer) > 0 {
+		slots := new(IntervalList)
+		if err := slots.Init(layerSlots, int64(i.LayerSlotCount)); err != nil {
+			i.invalidate(fmt.Errorf("layer_slots: %v", err))
+		}
+		for _, slot := range slots.Array() {
+			i.LayerSlotTable[slot] = true
+		}
+	}
+
 	return nil
 }
 
+// number returns the integer field key of m, 0 if missing.
+func (i *ExperimentInfo) number(m map[string]interface{}, key string) int64 {
+	v, ok := m[key]
+	if !ok || v == nil {
+		return 0
+	}
+
+	n, ok := v.(json.Number)
+	if !ok {
+		i.invalidate(fmt.Errorf("%s: %v is not a number", key, v))
+		return 0
+	}
+	x, err := n.Int64()
+	if err != nil {
+		i.invalidate(fmt.Errorf("%s: %v", key, err))
+	}
+	return x
+}
+
+// invalidate keeps the first reason the experiment is invalid.
+func (i *ExperimentInfo) invalidate(err error) {
+	if i.Invalid == nil {
+		i.Invalid = err
+	}
+}
+
 type ByVersionDesc []*ExperimentInfo
 
 func (s ByVersionDesc) Len() int           { return len(s) }
@@ -264,4 +570,37 @@ type DataResp struct {
 type GetConfigListData struct {
 	Time          int64                       `json:"time"`
 	ConfigListMap map[int64][]*ExperimentInfo `json:"config_list_map"`
+
+	// project_id => names of the experiments deleted since the requested version
+	DeletedMap map[int64][]string `json:"deleted_map,omitempty"`
+
+	// Full is set when ConfigListMap holds all of the experiments instead of the updated ones,
+	// so the local configs are replaced rather than merged.
+	Full bool `json:"full,omitempty"`
+}
+
+// ConfigSource supplies the experiment configs to the A/B client.
+type ConfigSource interface {
+	// GetConfigList returns the experiments updated since version ut,
+	// along with the version of the returned data.
+	// An empty ConfigListMap means the local configs are up to date.
+	GetConfigList(ut int64) (*GetConfigListData, error)
+}
+
+// StreamingConfigSource is a ConfigSource which can also push updates as they happen.
+type StreamingConfigSource interface {
+	ConfigSource
+
+	// Subscribe calls fn with every update published after version ut,
+	// and with nil once the stream is established and at every heartbeat,
+	// so the client can tell a live stream from a silent one.
+	// It blocks until ctx is done or the stream breaks.
+	Subscribe(ctx context.Context, ut int64, fn func(*GetConfigListData)) error
+}
+
+// StickyStore remembers the strategy an id was first bucketed into per experiment.
+// Implementations must be safe for concurrent use.
+type StickyStore interface {
+	Get(id, expID string) (strategyName string, ok bool)
+	Put(id, expID, strategyName string)
 }
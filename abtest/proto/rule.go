@@ -0,0 +1,339 @@
+package abtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Rule operators.
+const (
+	OpEq    = "eq"     // equal to value
+	OpNe    = "ne"     // not equal to value
+	OpGt    = "gt"     // greater than value
+	OpGte   = "gte"    // greater than or equal to value
+	OpLt    = "lt"     // less than value
+	OpLte   = "lte"    // less than or equal to value
+	OpIn    = "in"     // in the list of value
+	OpNotIn = "not_in" // not in the list of value
+
+	OpBetween = "between" // number in the closed range of value, [min, max]
+
+	OpSemverEq  = "semver_eq"
+	OpSemverNe  = "semver_ne"
+	OpSemverGt  = "semver_gt"
+	OpSemverGte = "semver_gte"
+	OpSemverLt  = "semver_lt"
+	OpSemverLte = "semver_lte"
+
+	OpRegex = "regex" // string matching the regular expression of value
+
+	// time before or after value, either RFC 3339 or unix seconds
+	OpBefore = "before"
+	OpAfter  = "after"
+)
+
+// Rule is a targeting expression: a comparison of an attribute with Value,
+// or the conjunction of All, the disjunction of Any, or the negation of Not.
+//
+//	{"all": [{"attr": "platform", "op": "eq", "value": "android"},
+//	         {"attr": "app_version", "op": "semver_gte", "value": "5.2"},
+//	         {"not": {"attr": "country", "op": "in", "value": ["CN", "RU"]}}]}
+//
+// eq, ne, gt, gte, lt and lte compare numbers numerically and anything else as strings.
+// A missing or malformed attribute matches no comparison.
+//
+// A rule is validated and compiled by Compile before use,
+// which UnmarshalJSON of ExperimentInfo does on load.
+type Rule struct {
+	Attr  string      `json:"attr,omitempty"`
+	Op    string      `json:"op,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+
+	All []*Rule `json:"all,omitempty"`
+	Any []*Rule `json:"any,omitempty"`
+	Not *Rule   `json:"not,omitempty"`
+
+	match func(v interface{}) bool // the compiled comparison
+}
+
+// Compile validates the rule and prepares its comparisons.
+func (r *Rule) Compile() error {
+	n := 0
+	for _, set := range []bool{len(r.Op) > 0, len(r.All) > 0, len(r.Any) > 0, r.Not != nil} {
+		if set {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("rule should have exactly one of op, all, any and not")
+	}
+
+	switch {
+	case len(r.All) > 0:
+		return compileRules(r.All)
+	case len(r.Any) > 0:
+		return compileRules(r.Any)
+	case r.Not != nil:
+		return r.Not.Compile()
+	}
+
+	if len(r.Attr) == 0 {
+		return fmt.Errorf("op %s: attr is empty", r.Op)
+	}
+
+	match, err := compileOp(r.Op, r.Value)
+	if err != nil {
+		return fmt.Errorf("op %s of %s: %v", r.Op, r.Attr, err)
+	}
+	r.match = match
+
+	return nil
+}
+
+func compileRules(rules []*Rule) error {
+	for _, sub := range rules {
+		if sub == nil {
+			return fmt.Errorf("rule is null")
+		}
+		if err := sub.Compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Match reports whether user satisfies the rule. A nil rule matches everyone,
+// a rule not compiled matches no one.
+func (r *Rule) Match(user *User) bool {
+	if r == nil {
+		return true
+	}
+
+	switch {
+	case len(r.All) > 0:
+		for _, sub := range r.All {
+			if !sub.Match(user) {
+				return false
+			}
+		}
+		return true
+	case len(r.Any) > 0:
+		for _, sub := range r.Any {
+			if sub.Match(user) {
+				return true
+			}
+		}
+		return false
+	case r.Not != nil:
+		return !r.Not.Match(user)
+	}
+
+	if r.match == nil {
+		return false
+	}
+
+	v, ok := user.Attr(r.Attr)
+	if !ok {
+		return false
+	}
+	return r.match(v)
+}
+
+func compileOp(op string, value interface{}) (match func(v interface{}) bool, err error) {
+	switch op {
+	case OpEq, OpNe, OpGt, OpGte, OpLt, OpLte:
+		if !isScalar(value) {
+			err = fmt.Errorf("value %v is not a scalar", value)
+			return
+		}
+		test := order(op)
+		match = func(v interface{}) bool {
+			return test(compare(v, value))
+		}
+	case OpIn, OpNotIn:
+		values, ok := value.([]interface{})
+		if !ok {
+			err = fmt.Errorf("value %v is not a list", value)
+			return
+		}
+		set := make(map[string]bool, len(values))
+		for _, value := range values {
+			if !isScalar(value) {
+				err = fmt.Errorf("value %v is not a scalar", value)
+				return
+			}
+			set[setKey(value)] = true
+		}
+		in := op == OpIn
+		match = func(v interface{}) bool {
+			return set[setKey(v)] == in
+		}
+	case OpBetween:
+		values, _ := value.([]interface{})
+		if len(values) != 2 {
+			err = fmt.Errorf("value %v is not a [min, max] range", value)
+			return
+		}
+		min, ok1 := toFloat(values[0])
+		max, ok2 := toFloat(values[1])
+		if !ok1 || !ok2 || min > max {
+			err = fmt.Errorf("value %v is not a [min, max] range", value)
+			return
+		}
+		match = func(v interface{}) bool {
+			f, ok := toFloat(v)
+			return ok && f >= min && f <= max
+		}
+	case OpSemverEq, OpSemverNe, OpSemverGt, OpSemverGte, OpSemverLt, OpSemverLte:
+		s, _ := value.(string)
+		var want Semver
+		if want, err = ParseSemver(s); err != nil {
+			return
+		}
+		test := order(op[len("semver_"):])
+		match = func(v interface{}) bool {
+			s, ok := v.(string)
+			if !ok {
+				return false
+			}
+			got, err := ParseSemver(s)
+			return err == nil && test(got.Compare(want))
+		}
+	case OpRegex:
+		s, ok := value.(string)
+		if !ok {
+			err = fmt.Errorf("value %v is not a string", value)
+			return
+		}
+		var re *regexp.Regexp
+		if re, err = regexp.Compile(s); err != nil {
+			return
+		}
+		match = func(v interface{}) bool {
+			s, ok := v.(string)
+			return ok && re.MatchString(s)
+		}
+	case OpBefore, OpAfter:
+		want, ok := toTime(value)
+		if !ok {
+			err = fmt.Errorf("value %v is not a time", value)
+			return
+		}
+		before := op == OpBefore
+		match = func(v interface{}) bool {
+			got, ok := toTime(v)
+			if !ok {
+				return false
+			}
+			if before {
+				return got.Before(want)
+			}
+			return got.After(want)
+		}
+	default:
+		err = fmt.Errorf("unknown op")
+	}
+
+	return
+}
+
+// order returns the test of the result of a comparison for the ordering op.
+func order(op string) func(c int) bool {
+	switch op {
+	case OpEq:
+		return func(c int) bool { return c == 0 }
+	case OpNe:
+		return func(c int) bool { return c != 0 }
+	case OpGt:
+		return func(c int) bool { return c > 0 }
+	case OpGte:
+		return func(c int) bool { return c >= 0 }
+	case OpLt:
+		return func(c int) bool { return c < 0 }
+	}
+	return func(c int) bool { return c <= 0 }
+}
+
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case string, bool:
+		return true
+	}
+	_, ok := toFloat(v)
+	return ok
+}
+
+// setKey makes equal numbers of any type the same key.
+func setKey(v interface{}) string {
+	if f, ok := toFloat(v); ok {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// compare returns -1, 0 or 1 as a is less than, equal to or greater than b.
+func compare(a, b interface{}) int {
+	if x, ok := toFloat(a); ok {
+		if y, ok := toFloat(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+
+	x, y := fmt.Sprint(a), fmt.Sprint(b)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func toFloat(v interface{}) (f float64, ok bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return
+}
+
+func toTime(v interface{}) (t time.Time, ok bool) {
+	switch x := v.(type) {
+	case time.Time:
+		return x, true
+	case string:
+		t, err := time.Parse(time.RFC3339, x)
+		return t, err == nil
+	}
+
+	if sec, ok := toFloat(v); ok {
+		return time.Unix(int64(sec), 0), true
+	}
+	return
+}
@@ -0,0 +1,105 @@
+package abtest
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSemverCompare(t *testing.T) {
+	for _, test := range []struct {
+		a, b string
+		want int
+	}{
+		{"5.10", "5.9", 1},
+		{"5.2", "5.2.0", 0},
+		{"v1.0.0", "1.0.0+build.1", 0},
+		{"1.0.0-alpha", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+	} {
+		a, err := ParseSemver(test.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ParseSemver(test.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := a.Compare(b); got != test.want {
+			t.Errorf("%s vs %s: got %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+
+	for _, s := range []string{"", "1.x", "1.2.3.4", "1.0.0-"} {
+		if _, err := ParseSemver(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
+
+func TestRuleMatch(t *testing.T) {
+	user := &User{Id: "u1", Attributes: map[string]interface{}{
+		"platform":    "android",
+		"app_version": "5.10.0",
+		"age":         30,
+		"country":     "DE",
+		"email":       "a@example.com",
+		"signup":      "2024-03-01T00:00:00Z",
+	}}
+
+	for _, test := range []struct {
+		rule string
+		want bool
+	}{
+		{`{"attr": "app_version", "op": "semver_gte", "value": "5.9"}`, true},
+		{`{"attr": "app_version", "op": "semver_lt", "value": "5.9"}`, false},
+		{`{"attr": "age", "op": "between", "value": [18, 30]}`, true},
+		{`{"attr": "age", "op": "between", "value": [31, 40]}`, false},
+		{`{"attr": "age", "op": "in", "value": [20, 30.0]}`, true},
+		{`{"attr": "country", "op": "not_in", "value": ["CN", "RU"]}`, true},
+		{`{"attr": "email", "op": "regex", "value": "@example\\.com$"}`, true},
+		{`{"attr": "signup", "op": "after", "value": "2024-01-01T00:00:00Z"}`, true},
+		{`{"attr": "signup", "op": "before", "value": 1704067200}`, false},
+		{`{"attr": "id", "op": "eq", "value": "u1"}`, true},
+		{`{"attr": "missing", "op": "ne", "value": "x"}`, false},
+		{`{"all": [{"attr": "platform", "op": "eq", "value": "android"}, {"not": {"attr": "country", "op": "eq", "value": "DE"}}]}`, false},
+		{`{"any": [{"attr": "platform", "op": "eq", "value": "ios"}, {"attr": "age", "op": "gt", "value": 18}]}`, true},
+	} {
+		rule := new(Rule)
+		if err := json.Unmarshal([]byte(test.rule), rule); err != nil {
+			t.Fatal(err)
+		}
+		if err := rule.Compile(); err != nil {
+			t.Fatalf("%s: %v", test.rule, err)
+		}
+		if got := rule.Match(user); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.rule, got, test.want)
+		}
+	}
+}
+
+func TestInvalidExperiment(t *testing.T) {
+	for _, data := range []string{
+		`{"name": "exp", "targeting": {"attr": "app_version", "op": "semver_gte", "value": "five"}}`,
+		`{"name": "exp", "targeting": {"attr": "email", "op": "regex", "value": "("}}`,
+		`{"name": "exp", "targeting": {"op": "eq", "value": 1}}`,
+		`{"name": "exp", "targeting": {"all": [{"attr": "a", "op": "eq", "value": 1}], "not": {"attr": "a", "op": "eq", "value": 1}}}`,
+		`{"name": "exp", "ut": "1"}`,
+		`{"name": "exp", "white_map": {"u1": 1}}`,
+		`{"name": "exp", "partition_count": 10, "partitions_map": {"treat": "5-20"}}`,
+		`{"name": "exp", "domain": "rec", "layer": "rank", "layer_slot_count": 0}`,
+	} {
+		info := new(ExperimentInfo)
+		if err := json.Unmarshal([]byte(data), info); err != nil {
+			t.Fatalf("%s: %v", data, err)
+		}
+		if info.Invalid == nil {
+			t.Errorf("%s: not invalid", data)
+			continue
+		}
+		if detail, err := info.GetStrategyDetail("u1"); err != ErrExperimentInvalid || detail.Reason != ReasonError {
+			t.Errorf("%s: got %+v, %v", data, detail, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package abtest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Semver is a semantic version, e.g. 5.10.1-beta.2.
+// Missing minor and patch numbers are 0, so "5.2" equals "5.2.0".
+type Semver struct {
+	Major, Minor, Patch uint64
+	Pre                 []string // dot separated pre-release identifiers
+}
+
+// ParseSemver parses s, optionally prefixed with "v". Build metadata is ignored.
+func ParseSemver(s string) (v Semver, err error) {
+	str := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexByte(str, '+'); i >= 0 {
+		str = str[:i]
+	}
+	if i := strings.IndexByte(str, '-'); i >= 0 {
+		v.Pre = strings.Split(str[i+1:], ".")
+		for _, id := range v.Pre {
+			if len(id) == 0 {
+				err = fmt.Errorf("invalid semver %q", s)
+				return
+			}
+		}
+		str = str[:i]
+	}
+
+	parts := strings.Split(str, ".")
+	if len(parts) > 3 {
+		err = fmt.Errorf("invalid semver %q", s)
+		return
+	}
+	nums := []*uint64{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		if *nums[i], err = strconv.ParseUint(part, 10, 64); err != nil {
+			err = fmt.Errorf("invalid semver %q", s)
+			return
+		}
+	}
+
+	return
+}
+
+// Compare returns -1, 0 or 1 as v is less than, equal to or greater than w,
+// in the precedence of semver 2.0.0.
+func (v Semver) Compare(w Semver) int {
+	if c := compareUint(v.Major, w.Major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Minor, w.Minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Patch, w.Patch); c != 0 {
+		return c
+	}
+
+	// A pre-release precedes the release.
+	switch {
+	case len(v.Pre) == 0 && len(w.Pre) == 0:
+		return 0
+	case len(v.Pre) == 0:
+		return 1
+	case len(w.Pre) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.Pre) && i < len(w.Pre); i++ {
+		a, aErr := strconv.ParseUint(v.Pre[i], 10, 64)
+		b, bErr := strconv.ParseUint(w.Pre[i], 10, 64)
+		var c int
+		switch {
+		case aErr == nil && bErr == nil:
+			c = compareUint(a, b)
+		case aErr == nil: // numeric identifiers precede alphanumeric ones
+			c = -1
+		case bErr == nil:
+			c = 1
+		default:
+			c = strings.Compare(v.Pre[i], w.Pre[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+
+	return compareUint(uint64(len(v.Pre)), uint64(len(w.Pre)))
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
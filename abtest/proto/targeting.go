@@ -0,0 +1,37 @@
+package abtest
+
+import "context"
+
+// User is the subject of an evaluation: the id to bucket and the attributes to target.
+type User struct {
+	Id         string
+	Attributes map[string]interface{} // e.g. country, platform, app_version, new_user
+}
+
+// Attr returns the attribute named name. "id" falls back to the id of the user.
+func (u *User) Attr(name string) (v interface{}, ok bool) {
+	if u == nil {
+		return
+	}
+	if v, ok = u.Attributes[name]; ok {
+		return
+	}
+	if name == "id" {
+		return u.Id, true
+	}
+	return
+}
+
+type userKey struct{}
+
+// NewUserContext returns a context carrying user,
+// whose attributes are used to evaluate the experiments for the id of the user.
+func NewUserContext(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFromContext returns the user carried by ctx, nil if none.
+func UserFromContext(ctx context.Context) *User {
+	user, _ := ctx.Value(userKey{}).(*User)
+	return user
+}
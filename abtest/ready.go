@@ -0,0 +1,101 @@
+package abtest
+
+import (
+	"context"
+	"time"
+
+	"github.com/phoenix-rec/abtest-sdk-go/abtest/consts"
+)
+
+// IsReady reports whether the configs have been loaded from the config source.
+// Configs restored from a snapshot do not count.
+func (c *ABClient) IsReady() bool {
+	if c == nil || c.ready == nil {
+		return false
+	}
+
+	select {
+	case <-c.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitReady blocks until the configs are loaded from the config source for the first time.
+// Meanwhile the waiters retry together, starting DefaultReadyRetryIntervalInSecond apart and
+// backing off up to the polling interval, so they do not flood an unavailable source.
+// It returns a *ReadyTimeoutError if ctx is done first, and ErrClientStopped if the client is closed.
+func (c *ABClient) WaitReady(ctx context.Context) (err error) {
+	if !c.isRunning() {
+		err = ErrClientStopped
+		return
+	}
+
+	ticker := time.NewTicker(consts.DefaultReadyRetryIntervalInSecond * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ready:
+			return
+		case <-ctx.Done():
+			err = &ReadyTimeoutError{Err: ctx.Err(), LastErr: c.lastError()}
+			return
+		case <-c.done:
+			err = ErrClientStopped
+			return
+		case <-ticker.C:
+			if !c.IsReady() && c.readyRetryDue() {
+				c.Update()
+			}
+		}
+	}
+}
+
+// readyRetryDue reports whether a waiter is to retry now, doubling the delay to the next retry
+// of any waiter up to the polling interval.
+func (c *ABClient) readyRetryDue() bool {
+	c.sm.Lock()
+	defer c.sm.Unlock()
+
+	now := time.Now()
+	if now.Before(c.nextReadyRetry) {
+		return false
+	}
+
+	minDelay := consts.DefaultReadyRetryIntervalInSecond * time.Second
+	maxDelay := time.Duration(c.interval) * time.Second
+	if maxDelay < minDelay {
+		maxDelay = minDelay
+	}
+	switch {
+	case c.readyRetryDelay == 0:
+		c.readyRetryDelay = minDelay
+	case c.readyRetryDelay < maxDelay:
+		c.readyRetryDelay *= 2
+	}
+	if c.readyRetryDelay > maxDelay {
+		c.readyRetryDelay = maxDelay
+	}
+	c.nextReadyRetry = now.Add(c.readyRetryDelay)
+	return true
+}
+
+func (c *ABClient) markReady() {
+	c.sm.Lock()
+	defer c.sm.Unlock()
+
+	select {
+	case <-c.ready:
+	default:
+		close(c.ready)
+	}
+}
+
+func (c *ABClient) lastError() error {
+	c.sm.Lock()
+	defer c.sm.Unlock()
+
+	return c.lastErr
+}
@@ -0,0 +1,130 @@
+package abtest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	logger "github.com/phoenix-rec/abtest-sdk-go/abtest/log"
+	abtest "github.com/phoenix-rec/abtest-sdk-go/abtest/proto"
+)
+
+// snapshotWriter hands the snapshots over from merge, which holds c.m, to a writer without it.
+// Only the latest version pending is written, so an older one never overwrites a newer one.
+type snapshotWriter struct {
+	m       sync.Mutex // guards the fields below
+	pending *snapshotVersion
+	writing bool // a writer is in flight and writes whatever is pending before it returns
+}
+
+type snapshotVersion struct {
+	ut      int64
+	infoMap map[int64]map[string]*abtest.ExperimentInfo
+}
+
+// set replaces the pending snapshot with version ut.
+func (w *snapshotWriter) set(ut int64, infoMap map[int64]map[string]*abtest.ExperimentInfo) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.pending = &snapshotVersion{ut: ut, infoMap: infoMap}
+}
+
+// writeSnapshot writes the pending snapshot, unless another writer is in flight to write it.
+// It must be called without holding c.m.
+func (c *ABClient) writeSnapshot() {
+	w := &c.snapshot
+	w.m.Lock()
+	if w.writing {
+		w.m.Unlock()
+		return
+	}
+
+	w.writing = true
+	for w.pending != nil {
+		version := w.pending
+		w.pending = nil
+		w.m.Unlock()
+
+		if err := saveSnapshot(c.options.SnapshotPath, version.ut, version.infoMap); err != nil {
+			logger.WarnF("save snapshot err: %v", err)
+		}
+
+		w.m.Lock()
+	}
+	w.writing = false
+	w.m.Unlock()
+}
+
+// loadSnapshot reads the snapshot written by saveSnapshot.
+// The snapshot has the same layout as the data of the config list API.
+func loadSnapshot(path string) (data *abtest.GetConfigListData, err error) {
+	if len(path) == 0 {
+		err = ErrSnapshotDisabled
+		return
+	}
+
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	data = new(abtest.GetConfigListData)
+	err = json.Unmarshal(bs, data)
+	return
+}
+
+// saveSnapshot atomically replaces the snapshot at path with infoMap:
+// the data is written to a temporary file in the same directory and then renamed,
+// so readers never see a partially written snapshot.
+func saveSnapshot(path string, ut int64, infoMap map[int64]map[string]*abtest.ExperimentInfo) (err error) {
+	if len(path) == 0 {
+		err = ErrSnapshotDisabled
+		return
+	}
+
+	data := &abtest.GetConfigListData{
+		Time:          ut,
+		ConfigListMap: make(map[int64][]*abtest.ExperimentInfo),
+	}
+	for projectId, expInfoMap := range infoMap {
+		expList := make([]*abtest.ExperimentInfo, 0, len(expInfoMap))
+		for _, info := range expInfoMap {
+			expList = append(expList, info)
+		}
+		sort.Slice(expList, func(i, j int) bool { return expList[i].Name < expList[j].Name })
+		data.ConfigListMap[projectId] = expList
+	}
+
+	bs, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(bs); err != nil {
+		f.Close()
+		return
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+
+	return os.Rename(f.Name(), path)
+}
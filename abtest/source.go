@@ -0,0 +1,201 @@
+package abtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/phoenix-rec/abtest-sdk-go/abtest/consts"
+	abtest "github.com/phoenix-rec/abtest-sdk-go/abtest/proto"
+)
+
+var (
+	_ abtest.ConfigSource = (*HTTPSource)(nil)
+	_ abtest.ConfigSource = (*FileSource)(nil)
+	_ abtest.ConfigSource = (*MemorySource)(nil)
+
+	_ abtest.StreamingConfigSource = (*MemorySource)(nil)
+)
+
+// HTTPSource reads configs from the config list API of the A/B server.
+type HTTPSource struct {
+	hostport string
+	client   *http.Client
+}
+
+func NewHTTPSource(hostport string) *HTTPSource {
+	return NewHTTPSourceWithClient(hostport, &http.Client{})
+}
+
+// NewHTTPSourceWithClient is like NewHTTPSource but sends requests with client,
+// which allows custom transports, proxies and timeouts.
+func NewHTTPSourceWithClient(hostport string, client *http.Client) *HTTPSource {
+	return &HTTPSource{
+		hostport: hostport,
+		client:   client,
+	}
+}
+
+func (s *HTTPSource) GetConfigList(ut int64) (data *abtest.GetConfigListData, err error) {
+	param := map[string]interface{}{
+		"time": ut,
+	}
+
+	resp, err := s.getConfigList(param)
+	if err != nil {
+		return
+	}
+	if resp.Ret != 1 || resp.Data == nil {
+		err = fmt.Errorf("unexpected resp: %v", resp)
+		return
+	}
+
+	return resp.Data, nil
+}
+
+func (s *HTTPSource) apiRequest(url string, httpBody []byte) (resp []byte, err error) {
+	request, err := http.NewRequest("POST", url, bytes.NewReader(httpBody))
+	if err != nil {
+		return
+	}
+
+	// 发起请求
+	httpResp, err := s.client.Do(request)
+	if err != nil {
+		return
+	}
+
+	defer httpResp.Body.Close()
+	resp, err = ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (s *HTTPSource) getConfigList(param map[string]interface{}) (resp *abtest.DataResp, err error) {
+	resp = new(abtest.DataResp)
+	url := s.hostport + consts.DefaultAbApiPath
+	data, err := json.Marshal(param)
+	if err != nil {
+		return
+	}
+	respBody, err := s.apiRequest(url, data)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(respBody, &resp)
+	return
+}
+
+// FileSource reads configs from a local file holding the data of the config list API,
+// e.g. a snapshot written by WithSnapshotPath.
+// The file is re-read on every update, so it can be replaced while the client is running.
+type FileSource struct {
+	path string
+}
+
+func NewFileSource(path string) *FileSource {
+	return &FileSource{path: path}
+}
+
+func (s *FileSource) GetConfigList(ut int64) (data *abtest.GetConfigListData, err error) {
+	bs, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return
+	}
+
+	data = new(abtest.GetConfigListData)
+	if err = json.Unmarshal(bs, data); err != nil {
+		return
+	}
+
+	return upToDate(data, ut), nil
+}
+
+// MemorySource serves configs held in memory, replaced as a whole by Set.
+// It is safe for concurrent use.
+type MemorySource struct {
+	m           sync.RWMutex
+	data        *abtest.GetConfigListData
+	subscribers map[chan *abtest.GetConfigListData]bool
+}
+
+func NewMemorySource(data *abtest.GetConfigListData) *MemorySource {
+	return &MemorySource{data: data}
+}
+
+// Set replaces the configs served by s. The client picks them up on its next update
+// as long as data.Time differs from the version it already holds.
+func (s *MemorySource) Set(data *abtest.GetConfigListData) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.data = data
+
+	// Only Set sends, so a drained buffer always has room for the latest data.
+	for ch := range s.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- data
+	}
+}
+
+// Subscribe pushes the data of every Set to fn until ctx is done,
+// so a client streaming from s picks up changes without polling. It sends no heartbeats.
+func (s *MemorySource) Subscribe(ctx context.Context, ut int64, fn func(*abtest.GetConfigListData)) error {
+	ch := make(chan *abtest.GetConfigListData, 1)
+	s.m.Lock()
+	if s.subscribers == nil {
+		s.subscribers = make(map[chan *abtest.GetConfigListData]bool)
+	}
+	s.subscribers[ch] = true
+	if s.data != nil && s.data.Time != ut {
+		// Set before subscribing.
+		ch <- s.data
+	}
+	s.m.Unlock()
+
+	defer func() {
+		s.m.Lock()
+		delete(s.subscribers, ch)
+		s.m.Unlock()
+	}()
+
+	fn(nil)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case data := <-ch:
+			fn(data)
+		}
+	}
+}
+
+func (s *MemorySource) GetConfigList(ut int64) (data *abtest.GetConfigListData, err error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	if s.data == nil {
+		return &abtest.GetConfigListData{Time: ut}, nil
+	}
+
+	return upToDate(s.data, ut), nil
+}
+
+// upToDate returns an empty update when data is the version the client already holds.
+func upToDate(data *abtest.GetConfigListData, ut int64) *abtest.GetConfigListData {
+	if ut != 0 && data.Time == ut {
+		return &abtest.GetConfigListData{Time: ut}
+	}
+
+	return data
+}
@@ -0,0 +1,109 @@
+package abtest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	proto "github.com/phoenix-rec/abtest-sdk-go/abtest/proto"
+)
+
+// testConfigList builds config list data the way the A/B server encodes it.
+func testConfigList(t *testing.T, ut int64, projectId int64, exps ...map[string]interface{}) *proto.GetConfigListData {
+	bs, err := json.Marshal(map[string]interface{}{
+		"time":            ut,
+		"config_list_map": map[int64][]map[string]interface{}{projectId: exps},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := new(proto.GetConfigListData)
+	if err := json.Unmarshal(bs, data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestMemorySource(t *testing.T) {
+	source := NewMemorySource(testConfigList(t, 1, 1, testExperiment("exp", 1)))
+	c, err := NewClient(1, proto.WithConfigSource(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if v := c.GetInt64("u1", "exp", "key", -1); v != 1 {
+		t.Errorf("got %d, want 1", v)
+	}
+
+	source.Set(testConfigList(t, 2, 1, testExperiment("exp", 2)))
+	if err := c.ab.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if v := c.GetInt64("u1", "exp", "key", -1); v != 2 {
+		t.Errorf("got %d, want 2", v)
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	bs, err := json.Marshal(testConfigList(t, 1, 1, testExperiment("exp", "file")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "configs.json")
+	if err := ioutil.WriteFile(path, bs, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewClient(1, proto.WithConfigSource(NewFileSource(path)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if v := c.GetString("u1", "exp", "key", ""); v != "file" {
+		t.Errorf("got %q, want file", v)
+	}
+}
+
+func TestTombstonesAndResync(t *testing.T) {
+	source := NewMemorySource(testConfigList(t, 1, 1, testExperiment("a", 1), testExperiment("b", 1)))
+	c, err := NewClient(1, proto.WithConfigSource(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	deleted := testConfigList(t, 2, 1)
+	deleted.DeletedMap = map[int64][]string{1: {"b"}}
+	source.Set(deleted)
+	if err := c.ab.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStrategyName("u1", "b"); err != ErrExperimentNotFound {
+		t.Errorf("got %v, want %v", err, ErrExperimentNotFound)
+	}
+	if _, err := c.GetStrategyName("u1", "a"); err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+
+	// The source forgets "a" without a tombstone, only a full resync drops it.
+	source.Set(testConfigList(t, 3, 1, testExperiment("c", 1)))
+	if err := c.ab.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStrategyName("u1", "a"); err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+	if err := c.ab.Resync(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetStrategyName("u1", "a"); err != ErrExperimentNotFound {
+		t.Errorf("got %v, want %v", err, ErrExperimentNotFound)
+	}
+	if _, err := c.GetStrategyName("u1", "c"); err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+}
@@ -0,0 +1,84 @@
+package abtest
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Status is a point-in-time view of the sync state of a client.
+type Status struct {
+	Ready     bool  // configs have been loaded from the config source
+	Streaming bool  // updates are pushed by the config stream rather than polled
+	Version   int64 // ut of the local configs, 0 if nothing is loaded
+
+	LastSyncTime        time.Time // last successful sync, zero if none
+	ConsecutiveFailures int       // failed syncs since the last successful one
+	LastError           error     // error of the last sync, nil if it succeeded
+	NextRetryTime       time.Time // when the next poll is due
+
+	// project_id => number of loaded experiments
+	Experiments map[int64]int
+
+	// project_id => the keys set by more than one enabled experiment, sorted
+	ContestedParams map[int64][]string
+
+	// ConfigConflicts counts the keys set by several experiments in GetConfig since the client started.
+	ConfigConflicts uint64
+}
+
+// Status reports the sync state of the client, e.g. for health pages
+// and alerting on stale configs.
+func (c *ABClient) Status() (status Status) {
+	status.Ready = c.IsReady()
+	status.Streaming = atomic.LoadInt32(&c.streaming) == 1
+	status.Version = atomic.LoadInt64(&c.ut)
+
+	c.sm.Lock()
+	status.LastSyncTime = c.lastSync
+	status.ConsecutiveFailures = c.consecutiveFailures
+	status.LastError = c.lastErr
+	status.NextRetryTime = c.nextRetry
+	c.sm.Unlock()
+
+	status.ConfigConflicts = atomic.LoadUint64(&c.conflicts)
+
+	status.Experiments = make(map[int64]int)
+	status.ContestedParams = make(map[int64][]string)
+	if v := c.loadView(); v != nil {
+		for projectId, expInfoMap := range v.infoMap {
+			status.Experiments[projectId] = len(expInfoMap)
+		}
+		for projectId, p := range v.projects {
+			if len(p.contested) > 0 {
+				status.ContestedParams[projectId] = append([]string(nil), p.contested...)
+			}
+		}
+	}
+
+	return
+}
+
+// recordResult keeps track of the outcome of every attempt to load the configs.
+func (c *ABClient) recordResult(err error) {
+	c.sm.Lock()
+	c.lastErr = err
+	if err != nil {
+		c.consecutiveFailures++
+	} else {
+		c.consecutiveFailures = 0
+		c.lastSync = time.Now()
+	}
+	c.sm.Unlock()
+
+	if err == nil {
+		c.markReady()
+	}
+}
+
+// scheduleRetry records that the next poll happens in ticks ticks.
+func (c *ABClient) scheduleRetry(ticks int) {
+	c.sm.Lock()
+	defer c.sm.Unlock()
+
+	c.nextRetry = time.Now().Add(time.Duration(ticks*c.interval) * time.Second)
+}
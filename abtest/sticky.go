@@ -0,0 +1,333 @@
+package abtest
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logger "github.com/phoenix-rec/abtest-sdk-go/abtest/log"
+	abtest "github.com/phoenix-rec/abtest-sdk-go/abtest/proto"
+)
+
+var (
+	_ abtest.StickyStore = (*MemoryStickyStore)(nil)
+	_ abtest.StickyStore = (*FileStickyStore)(nil)
+)
+
+type stickyKey struct {
+	id    string
+	expID string
+}
+
+type stickyEntry struct {
+	key          stickyKey
+	strategyName string
+}
+
+// MemoryStickyStore keeps the strategies of at most size ids in memory,
+// evicting the least recently used first. An evicted id is no longer sticky:
+// it is bucketed again by the current partitions, which may have moved it to another strategy,
+// so size should exceed the ids active in the sticky experiments. A size <= 0 never evicts.
+type MemoryStickyStore struct {
+	m     sync.Mutex
+	size  int
+	order *list.List // front is the most recently used
+	index map[stickyKey]*list.Element
+}
+
+func NewMemoryStickyStore(size int) *MemoryStickyStore {
+	return &MemoryStickyStore{
+		size:  size,
+		order: list.New(),
+		index: make(map[stickyKey]*list.Element),
+	}
+}
+
+func (s *MemoryStickyStore) Get(id, expID string) (strategyName string, ok bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	e, ok := s.index[stickyKey{id: id, expID: expID}]
+	if !ok {
+		return
+	}
+
+	s.order.MoveToFront(e)
+	return e.Value.(*stickyEntry).strategyName, true
+}
+
+func (s *MemoryStickyStore) Put(id, expID, strategyName string) {
+	key := stickyKey{id: id, expID: expID}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if e, ok := s.index[key]; ok {
+		e.Value.(*stickyEntry).strategyName = strategyName
+		s.order.MoveToFront(e)
+		return
+	}
+
+	s.index[key] = s.order.PushFront(&stickyEntry{key: key, strategyName: strategyName})
+	for s.size > 0 && s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.index, oldest.Value.(*stickyEntry).key)
+	}
+}
+
+type stickyRecord struct {
+	Id           string `json:"id"`
+	ExpID        string `json:"exp_id"`
+	StrategyName string `json:"strategy"`
+}
+
+const (
+	// stickyFlushInterval is how often new assignments are appended to the file.
+	stickyFlushInterval = time.Second
+
+	// The file is compacted to the live assignments once it holds stickyCompactRatio times
+	// as many lines, and at least stickyCompactMinLines.
+	stickyCompactRatio    = 4
+	stickyCompactMinLines = 1024
+)
+
+// FileStickyStore keeps the strategies of all ids in memory and in a JSON lines file,
+// so assignments survive restarts. The file is loaded on open with later lines taking precedence.
+// New assignments are appended in the background every second, so a crash loses the last second of them,
+// and the file is rewritten with the live assignments once it grows to four times as many lines.
+// Memory grows with the number of assignments, which are never evicted, as evicting breaks stickiness.
+type FileStickyStore struct {
+	m       sync.RWMutex // guards the fields below
+	entries map[stickyKey]string
+	pending []stickyRecord // assignments not written yet
+	lines   int            // lines written to the file
+
+	fm       sync.Mutex // guards the file
+	path     string
+	f        *os.File
+	torn     bool // a failed write may have left a line without its newline
+	errCount uint64
+
+	closeOnce sync.Once
+	closeChan chan struct{}
+	closed    chan struct{}
+}
+
+func NewFileStickyStore(path string) (s *FileStickyStore, err error) {
+	logger.InitDefaultLogger()
+	s = &FileStickyStore{
+		entries:   make(map[stickyKey]string),
+		path:      path,
+		closeChan: make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+
+	s.f, err = os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.load(); err != nil {
+		s.f.Close()
+		return nil, err
+	}
+
+	go s.run()
+	return
+}
+
+// load reads the file into entries, truncating a last line torn by a crash
+// so that the next append starts on a line of its own.
+func (s *FileStickyStore) load() error {
+	r := bufio.NewReader(s.f)
+	var size int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				return s.f.Truncate(size)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		size += int64(len(line))
+		s.lines++
+		var record stickyRecord
+		if json.Unmarshal(line, &record) != nil {
+			continue
+		}
+		s.entries[stickyKey{id: record.Id, expID: record.ExpID}] = record.StrategyName
+	}
+}
+
+func (s *FileStickyStore) Get(id, expID string) (strategyName string, ok bool) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	strategyName, ok = s.entries[stickyKey{id: id, expID: expID}]
+	return
+}
+
+// Put records the assignment in memory, it is written to the file in the background.
+func (s *FileStickyStore) Put(id, expID, strategyName string) {
+	key := stickyKey{id: id, expID: expID}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.entries[key] == strategyName {
+		return
+	}
+	s.entries[key] = strategyName
+	s.pending = append(s.pending, stickyRecord{Id: id, ExpID: expID, StrategyName: strategyName})
+}
+
+func (s *FileStickyStore) run() {
+	defer close(s.closed)
+
+	ticker := time.NewTicker(stickyFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closeChan:
+			s.flush()
+			return
+		case <-ticker.C:
+			s.flush()
+		}
+	}
+}
+
+// flush appends the pending assignments to the file, or rewrites it if it is due for compaction.
+// Assignments that fail to write stay pending for the next flush.
+func (s *FileStickyStore) flush() {
+	s.fm.Lock()
+	defer s.fm.Unlock()
+
+	s.m.Lock()
+	pending := s.pending
+	s.pending = nil
+	lines := s.lines + len(pending)
+	var snapshot []stickyRecord
+	if lines >= stickyCompactMinLines && lines > stickyCompactRatio*len(s.entries) {
+		snapshot = make([]stickyRecord, 0, len(s.entries))
+		for key, strategyName := range s.entries {
+			snapshot = append(snapshot, stickyRecord{Id: key.id, ExpID: key.expID, StrategyName: strategyName})
+		}
+	}
+	s.m.Unlock()
+
+	var err error
+	if snapshot != nil {
+		// The snapshot holds the pending assignments too.
+		if err = s.compact(snapshot); err == nil {
+			s.torn = false
+			s.m.Lock()
+			s.lines = len(snapshot)
+			s.m.Unlock()
+			return
+		}
+		s.trackErr(err)
+	}
+
+	if len(pending) == 0 {
+		return
+	}
+
+	written := len(pending)
+	if s.torn {
+		// End the torn line, loading skips it.
+		written++
+	}
+	if err = writeStickyRecords(s.f, s.torn, pending); err != nil {
+		s.trackErr(err)
+		s.torn = true
+		s.m.Lock()
+		s.pending = append(pending, s.pending...)
+		s.m.Unlock()
+		return
+	}
+
+	s.torn = false
+	s.m.Lock()
+	s.lines += written
+	s.m.Unlock()
+}
+
+func (s *FileStickyStore) trackErr(err error) {
+	errCount := atomic.AddUint64(&s.errCount, 1)
+	if errCount&(errCount-1) == 0 { // is power of two
+		logger.WarnF("sticky store write err: %v", err)
+	}
+}
+
+// compact replaces the file with records, renaming a new file over it.
+func (s *FileStickyStore) compact(records []stickyRecord) (err error) {
+	tmpPath := s.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_TRUNC, 0644)
+	if err != nil {
+		return
+	}
+	if err = writeStickyRecords(f, false, records); err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, s.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return
+	}
+
+	// f is the file at path now.
+	if s.f != nil {
+		s.f.Close()
+	}
+	s.f = f
+	return
+}
+
+// writeStickyRecords appends records to f, first ending the last line if newline is set.
+func writeStickyRecords(f *os.File, newline bool, records []stickyRecord) error {
+	if f == nil {
+		return os.ErrClosed
+	}
+
+	w := bufio.NewWriter(f)
+	if newline {
+		w.WriteByte('\n')
+	}
+	for _, r := range records {
+		bs, _ := json.Marshal(r)
+		w.Write(bs)
+		w.WriteByte('\n')
+	}
+	return w.Flush()
+}
+
+// Close writes the pending assignments and closes the file.
+func (s *FileStickyStore) Close() (err error) {
+	err = os.ErrClosed
+	s.closeOnce.Do(func() {
+		close(s.closeChan)
+		<-s.closed
+
+		s.fm.Lock()
+		defer s.fm.Unlock()
+
+		err = s.f.Close()
+		s.f = nil
+	})
+	return
+}
@@ -0,0 +1,177 @@
+package abtest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/phoenix-rec/abtest-sdk-go/abtest/consts"
+	logger "github.com/phoenix-rec/abtest-sdk-go/abtest/log"
+	abtest "github.com/phoenix-rec/abtest-sdk-go/abtest/proto"
+)
+
+const maxStreamEventSize = 64 << 20
+
+var _ abtest.StreamingConfigSource = (*HTTPSource)(nil)
+
+// Subscribe reads the Server-Sent Events stream of the A/B server.
+// The data of each event has the layout of the config list API
+// and holds only the experiments updated since the previous event.
+// fn is called with nil once the server answers with an event stream, and at every heartbeat.
+func (s *HTTPSource) Subscribe(ctx context.Context, ut int64, fn func(*abtest.GetConfigListData)) (err error) {
+	url := fmt.Sprintf("%s%s?time=%d", s.hostport, consts.DefaultAbStreamPath, ut)
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return
+	}
+	request.Header.Set("Accept", "text/event-stream")
+
+	httpResp, err := s.client.Do(request)
+	if err != nil {
+		return
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status: %s", httpResp.Status)
+		return
+	}
+	if contentType := httpResp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/event-stream") {
+		err = fmt.Errorf("unexpected content type: %q", contentType)
+		return
+	}
+	fn(nil)
+
+	scanner := bufio.NewScanner(httpResp.Body)
+	scanner.Buffer(make([]byte, 64<<10), maxStreamEventSize)
+
+	var event bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		switch {
+		case len(line) == 0:
+			// A blank line dispatches the event.
+			if event.Len() == 0 {
+				continue
+			}
+			data := new(abtest.GetConfigListData)
+			if err = json.Unmarshal(event.Bytes(), data); err != nil {
+				return
+			}
+			event.Reset()
+			fn(data)
+		case line[0] == ':':
+			// Comment, sent by the server as heartbeat.
+			fn(nil)
+		case bytes.HasPrefix(line, []byte("data:")):
+			if event.Len() > 0 {
+				event.WriteByte('\n')
+			}
+			line = bytes.TrimPrefix(line[len("data:"):], []byte(" "))
+			event.Write(line)
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return
+	}
+
+	return io.ErrUnexpectedEOF
+}
+
+// startStream keeps a stream to the config source open until the client is closed.
+// Polling is paused while the stream is up and takes over whenever it breaks,
+// or stays silent for longer than StreamIdleTimeout, until the stream is re-established.
+func (c *ABClient) startStream() {
+	if !c.options.Streaming {
+		return
+	}
+
+	source, ok := c.source.(abtest.StreamingConfigSource)
+	if !ok {
+		logger.WarnF("config source %T does not support streaming, polling every %d seconds", c.source, c.interval)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopStream = cancel
+	c.streamClosed = make(chan struct{})
+
+	go func(closed chan<- struct{}) {
+		defer close(closed)
+
+		// Failed attempts in a row, backing off the reconnects and their warnings,
+		// e.g. while the server has no stream endpoint.
+		var failures uint64
+		for ctx.Err() == nil {
+			if c.subscribe(ctx, source, failures+1) {
+				failures = 0
+			} else {
+				failures++
+			}
+
+			backoff := failures
+			if backoff > maxStreamBackoff {
+				backoff = maxStreamBackoff
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Duration(c.interval) * time.Second << backoff):
+			}
+		}
+	}(c.streamClosed)
+}
+
+// maxStreamBackoff caps the reconnect delay to 2^maxStreamBackoff polling intervals.
+const maxStreamBackoff = 5
+
+// subscribe streams the updates until the stream breaks or idles, reporting whether it was ever up.
+// The stream counts as up once the source calls fn, and polling is paused only from then on.
+func (c *ABClient) subscribe(ctx context.Context, source abtest.StreamingConfigSource, attempt uint64) bool {
+	defer func() {
+		atomic.StoreInt32(&c.streaming, 0)
+		if err := recover(); err != nil {
+			logger.ErrorF("stream err: %v", err)
+		}
+	}()
+
+	idleTimeout := c.options.StreamIdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = consts.DefaultStreamIdleTimeoutInSecond * time.Second
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var idle, established int32
+	timer := time.AfterFunc(idleTimeout, func() {
+		atomic.StoreInt32(&idle, 1)
+		cancel()
+	})
+	defer timer.Stop()
+
+	err := source.Subscribe(streamCtx, atomic.LoadInt64(&c.ut), func(data *abtest.GetConfigListData) {
+		timer.Reset(idleTimeout)
+		if atomic.CompareAndSwapInt32(&established, 0, 1) {
+			atomic.StoreInt32(&c.streaming, 1)
+		}
+		if data != nil {
+			c.apply(data)
+		}
+	})
+	if atomic.LoadInt32(&idle) == 1 {
+		err = fmt.Errorf("no event or heartbeat for %v", idleTimeout)
+	}
+
+	up := atomic.LoadInt32(&established) == 1
+	if ctx.Err() == nil && (up || attempt&(attempt-1) == 0) { // is power of two
+		logger.WarnF("config stream broken: %v, falling back to polling every %d seconds, attempt: %d", err, c.interval, attempt)
+	}
+	return up
+}
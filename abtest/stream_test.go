@@ -0,0 +1,195 @@
+package abtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/phoenix-rec/abtest-sdk-go/abtest/consts"
+	proto "github.com/phoenix-rec/abtest-sdk-go/abtest/proto"
+)
+
+func testConfigListJSON(ut int64, value interface{}) []byte {
+	bs, _ := json.Marshal(map[string]interface{}{
+		"time":            ut,
+		"config_list_map": map[int64][]map[string]interface{}{1: {testExperiment("exp", value)}},
+	})
+	return bs
+}
+
+func waitInt64(t *testing.T, c *Client, want int64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if c.GetInt64("u1", "exp", "key", -1) == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d, got %d", want, c.GetInt64("u1", "exp", "key", -1))
+}
+
+func TestStreamingFallback(t *testing.T) {
+	var pollUt int64 = 1
+	var streams int32
+	breakStream := make(chan struct{})
+	var once sync.Once
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(consts.DefaultAbApiPath, func(w http.ResponseWriter, r *http.Request) {
+		var param struct {
+			Time int64 `json:"time"`
+		}
+		json.NewDecoder(r.Body).Decode(&param)
+
+		ut := atomic.LoadInt64(&pollUt)
+		data := []byte(fmt.Sprintf(`{"time":%d,"config_list_map":{}}`, ut))
+		if param.Time < ut {
+			data = testConfigListJSON(ut, ut)
+		}
+		fmt.Fprintf(w, `{"ret":1,"data":%s}`, data)
+	})
+	mux.HandleFunc(consts.DefaultAbStreamPath, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&streams, 1) > 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, ": heartbeat\n\ndata: %s\n\n", testConfigListJSON(2, 2))
+		w.(http.Flusher).Flush()
+		<-breakStream
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	defer once.Do(func() { close(breakStream) })
+
+	c, err := NewClient(1, proto.WithHostport(server.URL), proto.WithInterval(1), proto.WithStreaming())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Pushed by the stream.
+	waitInt64(t, c, 2)
+
+	// Polled once the stream is broken.
+	atomic.StoreInt64(&pollUt, 3)
+	once.Do(func() { close(breakStream) })
+	waitInt64(t, c, 3)
+}
+
+func TestStreamingSilent(t *testing.T) {
+	for name, respond := range map[string]bool{"no response": false, "no heartbeat": true} {
+		t.Run(name, func(t *testing.T) {
+			var pollUt int64 = 1
+			hang := make(chan struct{})
+
+			mux := http.NewServeMux()
+			mux.HandleFunc(consts.DefaultAbApiPath, func(w http.ResponseWriter, r *http.Request) {
+				var param struct {
+					Time int64 `json:"time"`
+				}
+				json.NewDecoder(r.Body).Decode(&param)
+
+				ut := atomic.LoadInt64(&pollUt)
+				data := []byte(fmt.Sprintf(`{"time":%d,"config_list_map":{}}`, ut))
+				if param.Time < ut {
+					data = testConfigListJSON(ut, ut)
+				}
+				fmt.Fprintf(w, `{"ret":1,"data":%s}`, data)
+			})
+			mux.HandleFunc(consts.DefaultAbStreamPath, func(w http.ResponseWriter, r *http.Request) {
+				if respond {
+					w.Header().Set("Content-Type", "text/event-stream")
+					w.(http.Flusher).Flush()
+				}
+				select {
+				case <-hang:
+				case <-r.Context().Done():
+				}
+			})
+			server := httptest.NewServer(mux)
+			defer server.Close()
+			defer close(hang)
+
+			c, err := NewClient(1, proto.WithHostport(server.URL), proto.WithInterval(1),
+				proto.WithStreaming(), proto.WithStreamIdleTimeout(200*time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			// Polled although the stream endpoint accepts the connection.
+			atomic.StoreInt64(&pollUt, 2)
+			waitInt64(t, c, 2)
+			if c.Status().Streaming && !respond {
+				t.Error("streaming without a response")
+			}
+		})
+	}
+}
+
+func TestStreamingClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "abtest.snapshot")
+	source := NewMemorySource(testConfigList(t, 1, 1, testExperiment("exp", 1)))
+	c, err := NewClient(1, proto.WithConfigSource(source), proto.WithStreaming(), proto.WithSnapshotPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hold the stream in the middle of applying version 2.
+	entered, gate := make(chan struct{}), make(chan struct{})
+	var events int32
+	c.OnChange(func(e ChangeEvent) {
+		atomic.AddInt32(&events, 1)
+		if e.Version == 2 {
+			close(entered)
+			<-gate
+		}
+	})
+	exp := testExperiment("exp", 2)
+	exp["ut"] = 2
+	source.Set(testConfigList(t, 2, 1, exp))
+	<-entered
+
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Error("Close returned while the stream is applying an update")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(gate)
+	<-closed
+
+	snapshot, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version := c.Status().Version
+
+	source.Set(testConfigList(t, 3, 1, testExperiment("exp", 3)))
+	c.ab.apply(testConfigList(t, 4, 1, testExperiment("exp", 4)))
+	time.Sleep(50 * time.Millisecond)
+
+	if n := atomic.LoadInt32(&events); n != 1 {
+		t.Errorf("got %d events, want 1", n)
+	}
+	if v := c.Status().Version; v != version {
+		t.Errorf("got version %d after close, want %d", v, version)
+	}
+	if bs, _ := os.ReadFile(path); !bytes.Equal(bs, snapshot) {
+		t.Error("snapshot written after close")
+	}
+}
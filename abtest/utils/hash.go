@@ -2,13 +2,55 @@ package utils
 
 import (
 	"crypto/md5"
+	"encoding/binary"
 	"fmt"
-	"strconv"
 )
 
+// HashAlgorithm names the hash used to bucket ids into partitions.
+type HashAlgorithm = string
+
+const (
+	HashMD5     HashAlgorithm = "md5" // the default, compatible with the A/B server
+	HashMurmur3 HashAlgorithm = "murmur3"
+	HashXXHash  HashAlgorithm = "xxhash"
+)
+
+// CheckHashAlgorithm returns an error if alg is not supported. An empty alg is HashMD5.
+func CheckHashAlgorithm(alg HashAlgorithm) error {
+	switch alg {
+	case "", HashMD5, HashMurmur3, HashXXHash:
+		return nil
+	}
+	return fmt.Errorf("unknown hash algorithm %q", alg)
+}
+
+// HashIndex buckets id into one of totalCount partitions of the experiment with MD5.
 func HashIndex(expID, id string, totalCount uint64) (index uint64) {
-	s := fmt.Sprintf("%x", md5.Sum([]byte(expID+id)))
-	i, _ := strconv.ParseUint(s[24:], 16, 64)
-	index = i % totalCount
-	return
+	return HashIndexBy(HashMD5, expID, id, totalCount)
+}
+
+// HashIndexBy buckets id into one of totalCount partitions of the experiment with alg.
+// It does not allocate as long as expID and id are short.
+func HashIndexBy(alg HashAlgorithm, expID, id string, totalCount uint64) (index uint64) {
+	var buf [128]byte
+	data := append(append(buf[:0], expID...), id...)
+
+	var h uint64
+	switch alg {
+	case HashMurmur3:
+		h = Murmur3Sum64(data)
+	case HashXXHash:
+		h = XXHashSum64(data)
+	default:
+		h = uint64(MD5Sum32(data))
+	}
+
+	return h % totalCount
+}
+
+// MD5Sum32 returns the last 4 bytes of the MD5 digest of data as a big endian integer,
+// i.e. the last 8 hex digits the A/B server buckets by.
+func MD5Sum32(data []byte) uint32 {
+	sum := md5.Sum(data)
+	return binary.BigEndian.Uint32(sum[12:])
 }
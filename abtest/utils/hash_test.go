@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"crypto/md5"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Generated with github.com/spaolacci/murmur3 Sum64 and github.com/cespare/xxhash/v2 Sum64String.
+var hashVectors = []struct {
+	data            string
+	murmur3, xxhash uint64
+}{
+	{"", 0x0, 0xef46db3751d8e999},
+	{"a", 0x85555565f6597889, 0xd24ec4f1a98c6e5b},
+	{"abc", 0xb4963f3f3fad7867, 0x44bc2cf5ad770999},
+	{"exp_idu1", 0x9c91171b25222c73, 0xa2f9611634ceb49},
+	{"0123456789abcde", 0xa62dd5f6c0bf2351, 0x4bb51a30968e6a4d},
+	{"0123456789abcdef", 0x4be06d94cf4ad1a7, 0x5c5b90c34e376d0b},
+	{"0123456789abcdef0", 0xeb24ae8785a5c075, 0xf4e911320106d43c},
+	{"0123456789abcdef0123456789abcdef01234", 0x9cae64fd458b4ae6, 0x3f889232c2743804},
+	{strings.Repeat("xyz", 33), 0xcdd6001b9ffc0e39, 0x8273508d6b1aae61},
+}
+
+func TestHashVectors(t *testing.T) {
+	for _, v := range hashVectors {
+		if h := Murmur3Sum64([]byte(v.data)); h != v.murmur3 {
+			t.Errorf("murmur3(%q) = %#x, want %#x", v.data, h, v.murmur3)
+		}
+		if h := XXHashSum64([]byte(v.data)); h != v.xxhash {
+			t.Errorf("xxhash(%q) = %#x, want %#x", v.data, h, v.xxhash)
+		}
+	}
+}
+
+// legacyHashIndex is the former MD5 bucketing, kept to check compatibility.
+func legacyHashIndex(expID, id string, totalCount uint64) (index uint64) {
+	s := fmt.Sprintf("%x", md5.Sum([]byte(expID+id)))
+	i, _ := strconv.ParseUint(s[24:], 16, 64)
+	index = i % totalCount
+	return
+}
+
+func TestHashIndexCompatible(t *testing.T) {
+	for i := 0; i < 10000; i++ {
+		expID, id := fmt.Sprintf("exp%d", i%7), fmt.Sprintf("user_%d", i)
+		if got, want := HashIndex(expID, id, 1000), legacyHashIndex(expID, id, 1000); got != want {
+			t.Fatalf("HashIndex(%s, %s) = %d, want %d", expID, id, got, want)
+		}
+	}
+}
+
+func TestHashIndexAllocs(t *testing.T) {
+	for _, alg := range []HashAlgorithm{HashMD5, HashMurmur3, HashXXHash} {
+		if n := testing.AllocsPerRun(100, func() { HashIndexBy(alg, "exp_id", "user_1234567", 100) }); n != 0 {
+			t.Errorf("%s: %v allocs", alg, n)
+		}
+	}
+}
+
+func BenchmarkHashIndex(b *testing.B) {
+	b.Run("legacy", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			legacyHashIndex("exp_id", "user_1234567", 100)
+		}
+	})
+	for _, alg := range []HashAlgorithm{HashMD5, HashMurmur3, HashXXHash} {
+		b.Run(alg, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				HashIndexBy(alg, "exp_id", "user_1234567", 100)
+			}
+		})
+	}
+}
@@ -1,8 +1,14 @@
 module github.com/phoenix-rec/abtest-sdk-go
 
-go 1.16
+go 1.18
 
 require (
 	github.com/json-iterator/go v1.1.12
 	github.com/sirupsen/logrus v1.9.3
 )
+
+require (
+	github.com/modern-go/concurrent v0.0.0-20180228061459-e0a39a4cb421 // indirect
+	github.com/modern-go/reflect2 v1.0.2 // indirect
+	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
+)